@@ -0,0 +1,103 @@
+package config
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// argRest is a value of `arg` tag for a slice field that takes all remaining positional arguments
+const argRest = "rest"
+
+// positionalFields returns keys of fields set by positional arguments ordered by position
+// and a key of the field that takes the rest of arguments, if any.
+func (c *ConfReader) positionalFields(tagsInfo map[string]*flagInfo) ([]string, string, error) {
+	byPos := map[int]string{}
+	rest := ""
+	for k, info := range tagsInfo {
+		switch {
+		case info.Arg == "":
+			continue
+
+		case info.Arg == argRest:
+			if !c.isScalarSlice(info.Type) {
+				return nil, "", errors.Errorf("field %s with `arg:\"rest\"` tag must be a slice, got %s", k, info.Type)
+			}
+			if rest != "" {
+				return nil, "", errors.Errorf("fields %s and %s both take the rest of arguments", rest, k)
+			}
+			rest = k
+
+		default:
+			pos, err := strconv.Atoi(info.Arg)
+			if err != nil || pos < 0 {
+				return nil, "", errors.Errorf("invalid `arg` tag %q of field %s: must be a position starting from 0 or \"rest\"", info.Arg, k)
+			}
+			if other, ok := byPos[pos]; ok {
+				return nil, "", errors.Errorf("fields %s and %s are both bound to argument %d", other, k, pos)
+			}
+			byPos[pos] = k
+		}
+	}
+
+	positions := make([]int, 0, len(byPos))
+	for pos := range byPos {
+		positions = append(positions, pos)
+	}
+	sort.Ints(positions)
+
+	keys := make([]string, 0, len(positions))
+	for i, pos := range positions {
+		if pos != i {
+			return nil, "", errors.Errorf("field %s is bound to argument %d but argument %d is not bound to any field", byPos[pos], pos, i)
+		}
+		keys = append(keys, byPos[pos])
+	}
+	return keys, rest, nil
+}
+
+// argsBinding sets values of positional arguments left after parsing flags.
+// Arguments are ignored if the config struct has no fields with `arg` tags.
+func (c *ConfReader) argsBinding(merged *viper.Viper, tagsInfo map[string]*flagInfo, trace sourceTrace, args []string) error {
+	keys, rest, err := c.positionalFields(tagsInfo)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 && rest == "" {
+		return nil
+	}
+
+	for i, arg := range args {
+		if i >= len(keys) {
+			break
+		}
+		k := keys[i]
+		val, err := c.parseValue(arg, valueType(tagsInfo[k].Type))
+		if err != nil {
+			return &ArgError{Position: i, Field: k, Value: arg, Err: err}
+		}
+		merged.Set(k, val)
+		trace.add(k, Source{Kind: SourceArg, Name: strconv.Itoa(i), Value: val})
+	}
+
+	if len(args) <= len(keys) {
+		return nil
+	}
+	if rest == "" {
+		return &ArgError{Position: len(keys), Value: args[len(keys)], Err: errors.New("unexpected argument")}
+	}
+
+	values := make([]interface{}, 0, len(args)-len(keys))
+	for i := len(keys); i < len(args); i++ {
+		val, err := c.parseValue(args[i], tagsInfo[rest].Type.Elem())
+		if err != nil {
+			return &ArgError{Position: i, Field: rest, Value: args[i], Err: err}
+		}
+		values = append(values, val)
+	}
+	merged.Set(rest, values)
+	trace.add(rest, Source{Kind: SourceArg, Name: strconv.Itoa(len(keys)) + "...", Value: values})
+	return nil
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type DeployConfig struct {
+	Env     string   `arg:"0" validate:"required" usage:"target environment"`
+	Version string   `arg:"1"`
+	Files   []string `arg:"rest"`
+	DryRun  bool
+}
+
+func Test_Args(t *testing.T) {
+	t.Run("set", func(t *testing.T) {
+		cf := &DeployConfig{}
+		reader := NewConfReader("deploy").WithArgs([]string{"prod", "--dryrun", "v1.2", "a.yaml", "b.yaml"}).WithEnv(nil)
+		err := reader.Read(cf)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "prod", cf.Env)
+			assert.Equal(t, "v1.2", cf.Version)
+			assert.Equal(t, []string{"a.yaml", "b.yaml"}, cf.Files)
+			assert.True(t, cf.DryRun)
+
+			p, _ := reader.Provenance("env")
+			assert.Equal(t, Source{Kind: SourceArg, Name: "0", Value: "prod"}, p.Source)
+		}
+	})
+
+	t.Run("overridesEnv", func(t *testing.T) {
+		cf := &DeployConfig{}
+		err := NewConfReader("deploy").WithArgs([]string{"prod"}).WithEnv(map[string]string{"ENV": "dev", "VERSION": "v1"}).Read(cf)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "prod", cf.Env)
+			assert.Equal(t, "v1", cf.Version)
+		}
+	})
+
+	t.Run("required", func(t *testing.T) {
+		err := NewConfReader("deploy").WithArgs([]string{"--dryrun"}).WithEnv(nil).Read(&DeployConfig{})
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "env: failed on \"required\" (env ENV)")
+		}
+	})
+
+	t.Run("invalidValue", func(t *testing.T) {
+		type Scale struct {
+			Replicas int   `arg:"0"`
+			Ports    []int `arg:"rest"`
+		}
+		err := NewConfReader("scale").WithArgs([]string{"3", "80", "http"}).WithEnv(nil).Read(&Scale{})
+		var argErr *ArgError
+		if assert.ErrorAs(t, err, &argErr) {
+			assert.Equal(t, 2, argErr.Position)
+			assert.Equal(t, "ports", argErr.Field)
+			assert.Equal(t, "http", argErr.Value)
+		}
+	})
+
+	t.Run("unexpected", func(t *testing.T) {
+		type Single struct {
+			Name string `arg:"0"`
+		}
+		err := NewConfReader("single").WithArgs([]string{"a", "b"}).WithEnv(nil).Read(&Single{})
+		var argErr *ArgError
+		if assert.ErrorAs(t, err, &argErr) {
+			assert.Equal(t, 1, argErr.Position)
+			assert.Equal(t, `unexpected argument "b"`, err.Error())
+		}
+	})
+
+	t.Run("ignoredWithoutArgFields", func(t *testing.T) {
+		err := NewConfReader("no-args").WithArgs([]string{"a", "b"}).WithEnv(nil).Read(&FlagErrorConfig{})
+		assert.NoError(t, err)
+	})
+
+	t.Run("invalidTags", func(t *testing.T) {
+		type Gap struct {
+			A string `arg:"0"`
+			B string `arg:"2"`
+		}
+		type Duplicate struct {
+			A string `arg:"0"`
+			B string `arg:"0"`
+		}
+		type RestNotSlice struct {
+			A string `arg:"rest"`
+		}
+		type NotNumber struct {
+			A string `arg:"first"`
+		}
+
+		for _, cf := range []interface{}{&Gap{}, &Duplicate{}, &RestNotSlice{}, &NotNumber{}} {
+			err := NewConfReader("bad-args").WithArgs([]string{}).WithEnv(nil).Read(cf)
+			assert.Error(t, err, "%T", cf)
+		}
+	})
+
+	t.Run("help", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		err := NewConfReader("deploy").WithArgs([]string{"-h"}).WithEnv(nil).WithHelpOutput(out).Read(&DeployConfig{})
+		assert.Equal(t, ErrHelp, err)
+		lines := strings.Split(out.String(), "\n")
+		assert.Equal(t, "Usage: deploy [flags] <env> [version] [files...]", lines[0])
+		assert.Equal(t, "Arguments:", lines[2])
+		assert.Equal(t, "  env            string     target environment (env ENV, required)", lines[3])
+		assert.NotContains(t, out.String(), "--env")
+	})
+}
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// command is a subcommand declared by a `cmd` tag on a field of the config struct or registered by AddCommand.
+// Fields of a command are read only when the command is selected on the command line.
+type command struct {
+	name  string
+	usage string
+	// key is a path of the command config: a field path for `cmd` tags or the command name for registered commands.
+	// The config file section and env vars of command fields start with it, e.g. "serve.port" and SERVE_PORT.
+	key string
+	typ reflect.Type
+	// index is an index of the field with `cmd` tag, it is nil for registered commands
+	index []int
+	// config and handler are set for registered commands
+	config  interface{}
+	handler func() error
+}
+
+// runner is implemented by config structs of commands declared by `cmd` tags that Run should call
+type runner interface {
+	Run() error
+}
+
+// AddCommand registers a subcommand with its own config struct. configStruct must be a pointer to struct.
+// When the command is selected, e.g. `myapp serve --port 8080`, Read fills configStruct from the config file section,
+// env vars and flags of the command, while global fields are read into the struct passed to Read.
+// Run calls handler of the selected command.
+func (c *ConfReader) AddCommand(name string, configStruct interface{}, handler func() error) *ConfReader {
+	c.commands = append(c.commands, &command{name: name, key: strings.ToLower(name), config: configStruct, handler: handler})
+	return c
+}
+
+// Command returns the name of the command selected by the last Read, or an empty string if there is none.
+func (c *ConfReader) Command() string {
+	if c.selected == nil {
+		return ""
+	}
+	return c.selected.name
+}
+
+// Run reads config and runs the selected command: calls its handler for commands registered by AddCommand,
+// or Run() method of the command config for commands declared by `cmd` tags.
+// It returns ErrNoCommand if the config has commands but none was given.
+func (c *ConfReader) Run(configStruct interface{}) error {
+	if err := c.Read(configStruct); err != nil {
+		return err
+	}
+
+	cmd := c.selected
+	if cmd == nil {
+		commands, err := c.commandList(reflect.TypeOf(configStruct))
+		if err != nil || len(commands) == 0 {
+			return err
+		}
+		return ErrNoCommand
+	}
+
+	if cmd.handler != nil {
+		return cmd.handler()
+	}
+	if cmd.index != nil {
+		if r, ok := reflect.ValueOf(configStruct).Elem().FieldByIndex(cmd.index).Interface().(runner); ok {
+			return r.Run()
+		}
+	}
+	return errors.Errorf("command %s has no handler", cmd.name)
+}
+
+// commandList returns commands declared by `cmd` tags of the config struct and registered ones sorted by name
+func (c *ConfReader) commandList(t reflect.Type) ([]*command, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var res []*command
+	if t.Kind() == reflect.Struct {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := f.Tag.Get("cmd")
+			if name == "" {
+				continue
+			}
+			if f.Type.Kind() != reflect.Ptr || f.Type.Elem().Kind() != reflect.Struct {
+				return nil, errors.Errorf("field %s with `cmd` tag must be a pointer to struct, got %s", f.Name, f.Type)
+			}
+			res = append(res, &command{
+				name:  name,
+				usage: f.Tag.Get("usage"),
+				key:   strings.ToLower(f.Name),
+				typ:   f.Type.Elem(),
+				index: []int{i},
+			})
+		}
+	}
+
+	for _, cmd := range c.commands {
+		t := reflect.TypeOf(cmd.config)
+		if cmd.config == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+			return nil, errors.Errorf("config of command %s must be a pointer to struct, got %T", cmd.name, cmd.config)
+		}
+		cmd.typ = t.Elem()
+		res = append(res, cmd)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].name < res[j].name
+	})
+	for i, cmd := range res {
+		if cmd.name == "" || strings.HasPrefix(cmd.name, "-") {
+			return nil, errors.Errorf("invalid command name %q", cmd.name)
+		}
+		if i > 0 && res[i-1].name == cmd.name {
+			return nil, errors.Errorf("command %s is declared twice", cmd.name)
+		}
+	}
+	return res, nil
+}
+
+// dumpCommand adds fields of the command to fields. Flags of command fields are named relative to the command,
+// e.g. --port for the field "serve.port".
+func (c *ConfReader) dumpCommand(cmd *command, fields map[string]*flagInfo) {
+	for k, info := range c.dumpStruct(cmd.typ, cmd.key, cmd.index, map[string]*flagInfo{}) {
+		info.Cmd = cmd.name
+		if info.Name == k {
+			info.Name = strings.TrimPrefix(k, cmd.key+".")
+		}
+		if cmd.index == nil {
+			// fields of registered commands are not in the config struct
+			info.Index = nil
+		}
+		fields[k] = info
+	}
+}
+
+// findCommand returns a command by its name or nil if there is no such command
+func findCommand(commands []*command, name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// commandFields returns global fields and fields of the command
+func commandFields(fields map[string]*flagInfo, cmd *command) map[string]*flagInfo {
+	res := map[string]*flagInfo{}
+	for k, info := range fields {
+		if info.Cmd == "" || (cmd != nil && info.Cmd == cmd.name) {
+			res[k] = info
+		}
+	}
+	return res
+}
+
+// commandNames returns names of the commands
+func commandNames(commands []*command) []string {
+	names := make([]string, 0, len(commands))
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	return names
+}
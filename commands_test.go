@@ -0,0 +1,209 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type ServeCmd struct {
+	Port int `default:"8080" short:"p"`
+	Tls  struct {
+		Cert string
+	}
+	ran bool
+}
+
+func (s *ServeCmd) Run() error {
+	s.ran = true
+	return nil
+}
+
+type MigrateCmd struct {
+	Steps int `validate:"required"`
+}
+
+type CliConfig struct {
+	Verbose bool `short:"v"`
+	Db      struct {
+		Host string `default:"localhost"`
+	}
+	Serve   *ServeCmd   `cmd:"serve" usage:"start the server"`
+	Migrate *MigrateCmd `cmd:"migrate" usage:"run migrations"`
+}
+
+func Test_Commands(t *testing.T) {
+	newReader := func(args []string, env map[string]string, file string) *ConfReader {
+		return NewConfReader("cli").WithArgs(args).WithEnv(env).WithFS(fstest.MapFS{"cli.yaml": {Data: []byte(file)}})
+	}
+
+	t.Run("selected", func(t *testing.T) {
+		cf := &CliConfig{}
+		reader := newReader([]string{"-v", "serve", "--port", "9090", "--db.host", "db"}, nil, "")
+		if assert.NoError(t, reader.Read(cf)) {
+			assert.Equal(t, "serve", reader.Command())
+			assert.True(t, cf.Verbose)
+			assert.Equal(t, "db", cf.Db.Host)
+			if assert.NotNil(t, cf.Serve) {
+				assert.Equal(t, 9090, cf.Serve.Port)
+			}
+			// required fields of other commands are not validated
+			assert.Nil(t, cf.Migrate)
+		}
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		cf := &CliConfig{}
+		if assert.NoError(t, newReader([]string{"serve"}, nil, "").Read(cf)) {
+			assert.Equal(t, 8080, cf.Serve.Port)
+		}
+	})
+
+	t.Run("fileAndEnv", func(t *testing.T) {
+		cf := &CliConfig{}
+		reader := newReader([]string{"serve", "-p", "6060"}, map[string]string{"SERVE_TLS_CERT": "cert.pem"}, "serve:\n  port: 7070\nmigrate:\n  steps: 3\n")
+		if assert.NoError(t, reader.Read(cf)) {
+			assert.Equal(t, 6060, cf.Serve.Port)
+			assert.Equal(t, "cert.pem", cf.Serve.Tls.Cert)
+			assert.Nil(t, cf.Migrate)
+
+			p, _ := reader.Provenance("serve.port")
+			assert.Equal(t, "serve.port: flag --port \"6060\", overrides file cli.yaml \"7070\", overrides default \"8080\"", p.String())
+			_, ok := reader.Provenance("migrate.steps")
+			assert.False(t, ok)
+		}
+	})
+
+	t.Run("noCommand", func(t *testing.T) {
+		cf := &CliConfig{}
+		reader := newReader([]string{"--verbose"}, nil, "")
+		if assert.NoError(t, reader.Read(cf)) {
+			assert.Equal(t, "", reader.Command())
+			assert.Nil(t, cf.Serve)
+			assert.Nil(t, cf.Migrate)
+		}
+		assert.Equal(t, ErrNoCommand, newReader([]string{}, nil, "").Run(&CliConfig{}))
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		err := newReader([]string{"migrate"}, nil, "").Read(&CliConfig{})
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "migrate.steps: failed on \"required\"")
+		}
+	})
+
+	t.Run("unknownCommand", func(t *testing.T) {
+		err := newReader([]string{"deploy"}, nil, "").Read(&CliConfig{})
+		if assert.Error(t, err) {
+			assert.Equal(t, `unknown command "deploy", available commands: migrate, serve`, err.Error())
+		}
+	})
+
+	t.Run("commandFlagBeforeCommand", func(t *testing.T) {
+		err := newReader([]string{"--port", "1", "serve"}, nil, "").Read(&CliConfig{})
+		var flagErr *FlagError
+		if assert.ErrorAs(t, err, &flagErr) {
+			assert.Equal(t, FlagErrorUnknown, flagErr.Kind)
+			assert.Equal(t, "port", flagErr.Flag)
+		}
+	})
+
+	t.Run("run", func(t *testing.T) {
+		cf := &CliConfig{}
+		if assert.NoError(t, newReader([]string{"serve"}, nil, "").Run(cf)) {
+			assert.True(t, cf.Serve.ran)
+		}
+	})
+
+	t.Run("help", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		err := newReader([]string{"--help"}, nil, "").WithHelpOutput(out).Read(&CliConfig{})
+		assert.Equal(t, ErrHelp, err)
+		assert.Equal(t, `Usage: cli [flags] <command>
+
+Commands:
+  migrate                  run migrations
+  serve                    start the server
+
+Flags:
+  -h, --help               show help
+  -v, --verbose            (env VERBOSE)
+
+db.*:
+      --db.host   string   (default "localhost", env DB_HOST)
+`, out.String())
+
+		out.Reset()
+		err = newReader([]string{"serve", "--help"}, nil, "").WithHelpOutput(out).Read(&CliConfig{})
+		assert.Equal(t, ErrHelp, err)
+		assert.Equal(t, `Usage: cli serve [flags]
+
+Flags:
+  -h, --help                show help
+  -v, --verbose             (env VERBOSE)
+
+db.*:
+      --db.host    string   (default "localhost", env DB_HOST)
+
+serve.*:
+  -p, --port       int      (default 8080, env SERVE_PORT)
+
+serve.tls.*:
+      --tls.cert   string   (env SERVE_TLS_CERT)
+`, out.String())
+	})
+}
+
+type RegisteredCmd struct {
+	Target string `validate:"required"`
+	Force  bool
+}
+
+func Test_AddCommand(t *testing.T) {
+	global := &FlagErrorConfig{}
+	cmd := &RegisteredCmd{}
+	ran := false
+	reader := NewConfReader("cli").
+		WithArgs([]string{"--host", "h", "deploy", "--force"}).
+		WithEnv(map[string]string{"DEPLOY_TARGET": "prod"}).
+		WithFS(fstest.MapFS{}).
+		AddCommand("deploy", cmd, func() error {
+			ran = true
+			return nil
+		})
+
+	if assert.NoError(t, reader.Run(global)) {
+		assert.True(t, ran)
+		assert.Equal(t, "deploy", reader.Command())
+		assert.Equal(t, "h", global.Host)
+		assert.Equal(t, RegisteredCmd{Target: "prod", Force: true}, *cmd)
+	}
+
+	t.Run("validation", func(t *testing.T) {
+		err := NewConfReader("cli").WithArgs([]string{"deploy"}).WithEnv(nil).WithFS(fstest.MapFS{}).
+			AddCommand("deploy", &RegisteredCmd{}, nil).Read(&FlagErrorConfig{})
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "deploy.target: failed on \"required\"")
+		}
+		var validationErr *ValidationError
+		if assert.ErrorAs(t, err, &validationErr) {
+			assert.Equal(t, []FieldError{{Field: "deploy.target", Env: "DEPLOY_TARGET", Flag: "target", Rule: "required", Value: ""}}, validationErr.Fields)
+		}
+	})
+
+	t.Run("notPointer", func(t *testing.T) {
+		err := NewConfReader("cli").WithArgs([]string{}).WithEnv(nil).
+			AddCommand("deploy", RegisteredCmd{}, nil).Read(&FlagErrorConfig{})
+		assert.Error(t, err)
+	})
+
+	t.Run("handlerError", func(t *testing.T) {
+		errFailed := errors.New("failed")
+		err := NewConfReader("cli").WithArgs([]string{"deploy"}).WithEnv(map[string]string{"DEPLOY_TARGET": "prod"}).WithFS(fstest.MapFS{}).
+			AddCommand("deploy", &RegisteredCmd{}, func() error { return errFailed }).Run(&FlagErrorConfig{})
+		assert.Equal(t, errFailed, err)
+	})
+}
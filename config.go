@@ -1,17 +1,24 @@
 package config
 
 import (
+	"bytes"
 	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"os"
+	"path"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/creasty/defaults"
-	"github.com/fsnotify/fsnotify"
 	"github.com/go-playground/validator/v10"
-	"github.com/iamolegga/enviper"
+	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -38,44 +45,239 @@ nested:
 	foo: bar
 */
 type ConfReader struct {
-	viper        *enviper.Enviper
+	viper        *viper.Viper
 	configName   string
 	configDirs   []string
 	envVarPrefix string
 	Verbose      bool
 	configStruct any
+	provenance   map[string]FieldProvenance
+	// configFile is a path of the config file used by the last Read
+	configFile string
+	// args, envLookup and fs replace os.Args, process environment and OS filesystem when set
+	args      []string
+	envLookup func(string) (string, bool)
+	fs        fs.FS
+	// envNames are names of env vars set by WithEnv, they are used to find map keys set by env vars
+	envNames []string
+	// strict makes Read fail on keys of the config file and prefixed env vars that don't match any field
+	strict bool
+	// exitOnFlagError makes Read print usage and exit the process if flags could not be parsed
+	exitOnFlagError bool
+	// helpOutput is where help is printed, os.Stderr if not set
+	helpOutput io.Writer
+	// reloadOnSIGHUP makes watchers reload config on SIGHUP
+	reloadOnSIGHUP bool
+	// pollInterval makes watchers reload config periodically if set
+	pollInterval time.Duration
+
+	// flagSet is an external flag set to add flags to, flagsBound is true when flags are added to it
+	flagSet    *pflag.FlagSet
+	flagsBound bool
+
+	// commands are registered by AddCommand, selected is the command selected by the last Read
+	commands []*command
+	selected *command
+
+	// validation checks `validate` tags, it is created on the first Read if not set by WithValidator
+	validation *validator.Validate
+
+	// typeHooks are parsers for custom types of fields
+	typeHooks map[reflect.Type]func(string) (interface{}, error)
+
+	// mu guards configStruct and provenance during reload
+	mu sync.RWMutex
+	// reloadMu serializes reloads
+	reloadMu       sync.Mutex
+	handlersMu     sync.Mutex
+	changeHandlers []func(old, new interface{}, changes []FieldChange)
+	errorHandlers  []func(err error)
 }
 
 // NewConfReader creates new instance of ConfReader
 // configName is a name of config file name without extension and evn vars prefix
 func NewConfReader(configName string) *ConfReader {
 	return &ConfReader{
-		viper:        enviper.New(viper.New()),
+		viper:        viper.New(),
 		configName:   configName,
 		envVarPrefix: "",
+		typeHooks:    defaultTypeHooks(),
 	}
 }
 
 // Read reads config from config file, env vars or flags.
 func (c *ConfReader) Read(configStruct interface{}) error {
+	res, err := c.read(configStruct)
+	if err != nil {
+		return err
+	}
+
+	c.configStruct = configStruct
+	c.provenance = res.trace.provenance()
+	c.selected = res.command
+	res.setCommandConfig()
+	return nil
+}
+
+// readResult is a result of reading config
+type readResult struct {
+	// fields are fields of the config struct and of the selected command
+	fields map[string]*flagInfo
+	trace  sourceTrace
+	// command is the selected command, if any
+	command *command
+	// commandConfig is a new value of the config of the selected registered command
+	commandConfig reflect.Value
+}
+
+// setCommandConfig copies the config of the selected registered command into the struct passed to AddCommand
+func (r *readResult) setCommandConfig() {
+	if r.commandConfig.IsValid() {
+		reflect.ValueOf(r.command.config).Elem().Set(r.commandConfig.Elem())
+	}
+}
+
+// read reads config into configStruct without changing state of the reader.
+// It returns fields of the config struct, sources of their values and the selected command.
+func (c *ConfReader) read(configStruct interface{}) (*readResult, error) {
 	// validate the input struct
 	rval := reflect.ValueOf(configStruct)
 	if configStruct == nil || rval == reflect.Zero(rval.Type()) {
-		return errors.New("config struct is nil")
+		return nil, errors.New("config struct is nil")
 	}
 
 	if rval.Kind() != reflect.Ptr {
-		return errors.New("config struct must be pointer")
+		return nil, errors.New("config struct must be pointer")
+	}
+
+	tagsInfo, commands, err := c.fields(rval.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	// flags are parsed first since the command they select defines which fields are read
+	cmd, flagSets, args, err := c.parseCommandLine(tagsInfo, commands)
+	if err != nil {
+		return nil, err
+	}
+	allFields := tagsInfo
+	if len(commands) > 0 {
+		tagsInfo = commandFields(tagsInfo, cmd)
+	}
+	if cmd != nil && cmd.index != nil {
+		// config of the selected command is allocated, so it gets default values; configs of other commands stay nil
+		if f := rval.Elem().FieldByIndex(cmd.index); f.IsNil() {
+			f.Set(reflect.New(cmd.typ))
+		}
 	}
 
 	// set default values
 	if err := defaults.Set(configStruct); err != nil {
-		return errors.Wrap(err, "failed to set default values")
+		return nil, errors.Wrap(err, "failed to set default values")
 	}
 
 	// jww.SetLogThreshold(jww.LevelTrace)
 	// jww.SetStdoutThreshold(jww.LevelTrace)
 
+	// c.viper holds only values from the config file, the other sources are layered on top of it
+	// in a fresh instance so we always know which source provided which value
+	if err := c.readConfigFile(); err != nil {
+		return nil, err
+	}
+	if c.strict {
+		if err := c.checkStrict(allFields); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.addElemEnv(tagsInfo); err != nil {
+		return nil, err
+	}
+	trace := newSourceTrace(tagsInfo, c.viper, c.configFile)
+
+	settings := c.viper.AllSettings()
+	for _, other := range commands {
+		if other != cmd {
+			delete(settings, other.key)
+		}
+	}
+	merged := viper.New()
+	if err := merged.MergeConfigMap(settings); err != nil {
+		return nil, errors.Wrap(err, "failed to merge config file values")
+	}
+
+	// Bind env vars
+	if err := c.envBinding(merged, tagsInfo, trace); err != nil {
+		return nil, err
+	}
+
+	// Bind flags
+	if err := c.flagsBinding(merged, tagsInfo, trace, flagSets, args); err != nil {
+		return nil, err
+	}
+
+	err = merged.Unmarshal(configStruct, c.decodeHook())
+	if err != nil {
+		return nil, errors.Wrap(newDecodeError(err, "", trace), "failed to unmarshal struct")
+	}
+
+	res := &readResult{fields: tagsInfo, trace: trace, command: cmd}
+	if cmd != nil && cmd.config != nil {
+		res.commandConfig = reflect.New(cmd.typ)
+		if err := defaults.Set(res.commandConfig.Interface()); err != nil {
+			return nil, errors.Wrap(err, "failed to set default values")
+		}
+		if err := merged.UnmarshalKey(cmd.key, res.commandConfig.Interface(), c.decodeHook()); err != nil {
+			return nil, errors.Wrap(newDecodeError(err, cmd.key, trace), "failed to unmarshal struct")
+		}
+	}
+
+	// validate struct
+	if err := c.validate(configStruct, "", tagsInfo); err != nil {
+		return nil, err
+	}
+	if res.commandConfig.IsValid() {
+		if err := c.validate(res.commandConfig.Interface(), cmd.key, tagsInfo); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// fields returns fields of the config struct and its commands
+func (c *ConfReader) fields(t reflect.Type) (map[string]*flagInfo, []*command, error) {
+	commands, err := c.commandList(t)
+	if err != nil {
+		return nil, nil, err
+	}
+	tagsInfo := c.dumpStruct(t, "", nil, map[string]*flagInfo{})
+	for _, cmd := range commands {
+		c.dumpCommand(cmd, tagsInfo)
+	}
+
+	if err := c.parseDefaults(tagsInfo); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to set default values")
+	}
+	return tagsInfo, commands, nil
+}
+
+// decodeHook returns the option that makes viper decode values of all supported types from strings
+func (c *ConfReader) decodeHook() viper.DecoderConfigOption {
+	return viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		c.decodeCustomType,
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	))
+}
+
+// readConfigFile searches for the config file and reads it into c.viper.
+// Missing config file is not an error.
+func (c *ConfReader) readConfigFile() error {
+	if c.fs != nil {
+		return c.readConfigFileFS()
+	}
+
 	c.viper.SetConfigFile(c.configName)
 
 	if len(c.configDirs) == 0 {
@@ -93,169 +295,479 @@ func (c *ConfReader) Read(configStruct interface{}) error {
 		}
 	}
 	c.viper.SetConfigName(c.configName)
-	c.viper.SetEnvPrefix(c.envVarPrefix)
 
-	// Bind flags
-	if err := c.flagsBinding(configStruct); err != nil {
-		return err
+	if err := c.viper.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			file := c.viper.ConfigFileUsed()
+			data, readErr := os.ReadFile(file)
+			if readErr != nil {
+				return errors.Wrap(err, "failed to unmarshal struct")
+			}
+			return errors.Wrap(newParseError(file, data, err), "failed to unmarshal struct")
+		}
 	}
+	c.configFile = c.viper.ConfigFileUsed()
+	return nil
+}
 
-	err := c.viper.Unmarshal(configStruct)
-	if err != nil {
-		return errors.Wrap(err, "failed to unmarshal struct")
+// readConfigFileFS searches for the config file in search dirs of c.fs. Root of c.fs is searched if no dirs are set.
+func (c *ConfReader) readConfigFileFS() error {
+	dirs := c.configDirs
+	if len(dirs) == 0 {
+		dirs = []string{"."}
 	}
 
-	// validate struct
-	err = validator.New().Struct(configStruct)
-	if err != nil {
-		validationErrors := err.(validator.ValidationErrors)
-		if len(validationErrors) > 0 {
+	for _, dir := range dirs {
+		for _, ext := range viper.SupportedExts {
+			file := path.Join(dir, c.configName+"."+ext)
+			b, err := fs.ReadFile(c.fs, file)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
 			if err != nil {
-				return errors.Wrap(err, "validation error")
+				return errors.Wrap(err, "failed to read config file")
+			}
+
+			c.viper.SetConfigType(ext)
+			if err := c.viper.ReadConfig(bytes.NewReader(b)); err != nil {
+				return errors.Wrap(newParseError(file, b, err), "failed to unmarshal struct")
 			}
+			c.configFile = file
+			return nil
 		}
-		return err
 	}
+	return nil
+}
 
-	c.configStruct = configStruct
+// envBinding sets values of environment variables that match config fields.
+// A name from the `envvar` tag has precedence over the name derived from the field path.
+func (c *ConfReader) envBinding(merged *viper.Viper, tagsInfo map[string]*flagInfo, trace sourceTrace) error {
+	for _, k := range sortedKeys(tagsInfo) {
+		info := tagsInfo[k]
+		for _, name := range c.envVarNames(k, info) {
+			// empty values are treated as not set
+			val, ok := c.lookupEnv(name)
+			if !ok || val == "" {
+				continue
+			}
+
+			var v interface{} = val
+			switch {
+			case c.isCustomType(info.Type):
+				custom, err := c.parseCustom(val, info.Type)
+				if err != nil {
+					return &EnvError{Var: name, Field: k, Value: val, Err: err}
+				}
+				v = custom
+
+			case c.isScalarMap(info.Type):
+				// maps are set as "k1=v1,k2=v2" and merged with keys from the config file
+				m := newMapValue(info.Type, c.parseValue)
+				if err := m.Set(val); err != nil {
+					return &EnvError{Var: name, Field: k, Value: val, Err: err}
+				}
+				v = m.value
+
+			case c.isScalarSlice(info.Type):
+				// slices are set as comma separated values
+				sv := newSliceValue(info.Type, c.parseValue)
+				if err := sv.Set(val); err != nil {
+					return &EnvError{Var: name, Field: k, Value: val, Err: err}
+				}
+				v = sv.value
+			}
+			trace.add(k, Source{Kind: SourceEnv, Name: name, Value: v})
+
+			if m, ok := v.(map[string]interface{}); ok {
+				v = mergeMap(getValue(merged, k, info), m)
+			}
+			if err := setValue(merged, k, info, v); err != nil {
+				return &EnvError{Var: name, Field: k, Value: val, Err: err}
+			}
+			break
+		}
+	}
 	return nil
 }
 
-func (c *ConfReader) flagsBinding(conf interface{}) error {
-	t := reflect.TypeOf(conf)
-	tagsInfo := map[string]*flagInfo{}
-	tagsInfo = c.dumpStruct(t, "", tagsInfo)
+func (c *ConfReader) lookupEnv(name string) (string, bool) {
+	if c.envLookup != nil {
+		return c.envLookup(name)
+	}
+	return os.LookupEnv(name)
+}
+
+// envVarNames returns names of environment variables that could set the field in order of precedence.
+func (c *ConfReader) envVarNames(key string, info *flagInfo) []string {
+	name := strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+	if c.envVarPrefix != "" {
+		name = strings.ToUpper(c.envVarPrefix) + "_" + name
+	}
+
+	if info.EnvVar != "" && info.EnvVar != name {
+		return []string{info.EnvVar, name}
+	}
+	return []string{name}
+}
+
+// parseCommandLine parses flags and selects the command. It returns flag sets in order of parsing and positional arguments.
+// Global flags could be set before and after the command name, while flags of the command only after it.
+func (c *ConfReader) parseCommandLine(fields map[string]*flagInfo, commands []*command) (*command, []*pflag.FlagSet, []string, error) {
+	name, args := c.commandLine()
+	if c.flagSet != nil {
+		return c.parseExternalFlags(fields, commands, args)
+	}
+	c.addElemFlags(fields, args)
+	if len(commands) == 0 {
+		flags, err := c.newFlagSet(name, fields, nil)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if err := c.parseFlags(flags, args, fields); err != nil {
+			return nil, nil, nil, err
+		}
+		return nil, []*pflag.FlagSet{flags}, flags.Args(), nil
+	}
 
-	var flags = pflag.NewFlagSet(os.Args[0], pflag.ExitOnError)
+	global := commandFields(fields, nil)
+	flags, err := c.newFlagSet(name, global, commands)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// parsing stops at the command name
+	flags.SetInterspersed(false)
+	if err := c.parseFlags(flags, args, global); err != nil {
+		return nil, nil, nil, err
+	}
+	rest := flags.Args()
+	if len(rest) == 0 {
+		return nil, []*pflag.FlagSet{flags}, rest, nil
+	}
 
-	for _, v := range tagsInfo {
-		switch v.Type.Kind() {
+	cmd := findCommand(commands, rest[0])
+	if cmd == nil {
+		return nil, nil, nil, errors.Errorf("unknown command %q, available commands: %s", rest[0], strings.Join(commandNames(commands), ", "))
+	}
+	cmdFields := commandFields(fields, cmd)
+	cmdFlags, err := c.newFlagSet(name+" "+cmd.name, cmdFields, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := c.parseFlags(cmdFlags, rest[1:], cmdFields); err != nil {
+		return nil, nil, nil, err
+	}
+	return cmd, []*pflag.FlagSet{flags, cmdFlags}, cmdFlags.Args(), nil
+}
+
+// newFlagSet creates a flag set with flags of the fields. commands are listed in help.
+func (c *ConfReader) newFlagSet(name string, fields map[string]*flagInfo, commands []*command) (*pflag.FlagSet, error) {
+	var flags = pflag.NewFlagSet(name, pflag.ContinueOnError)
+	flags.SetOutput(c.helpWriter())
+	flags.Usage = func() {
+		c.writeHelp(c.helpWriter(), name, fields, flags, commands)
+	}
+	if err := c.addFlags(flags, fields); err != nil {
+		return nil, err
+	}
+	return flags, nil
+}
+
+// addFlags adds flags of the fields to the flag set
+func (c *ConfReader) addFlags(flags *pflag.FlagSet, fields map[string]*flagInfo) error {
+	if err := checkShorthands(fields); err != nil {
+		return err
+	}
+	if _, _, err := c.positionalFields(fields); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// names maps flag names to fields
+	names := map[string]string{}
+	for _, k := range keys {
+		v := fields[k]
+		if v.Arg != "" {
+			// fields set by positional arguments don't have flags
+			continue
+		}
+		if other, ok := names[v.Name]; ok {
+			return errors.Errorf("flag --%s of field %s is already used by field %s", v.Name, k, other)
+		}
+		if flags.Lookup(v.Name) != nil {
+			return errors.Errorf("flag --%s of field %s is already defined", v.Name, k)
+		}
+		if v.Short != "" && flags.ShorthandLookup(v.Short) != nil {
+			return errors.Errorf("shorthand -%s of flag --%s is already defined", v.Short, v.Name)
+		}
+		names[v.Name] = k
+
+		if c.isCustomType(v.Type) {
+			flags.VarP(newTextValue(v.Type, c.parseCustom), v.Name, v.Short, v.Usage)
+			continue
+		}
+
+		switch valueType(v.Type).Kind() {
 		case reflect.String:
-			flags.String(v.Name, v.DefaultVal, v.Usage)
+			flags.StringP(v.Name, v.Short, "", v.Usage)
 
 		case reflect.Bool:
-			flags.Bool(v.Name, false, v.Usage)
+			flags.BoolP(v.Name, v.Short, false, v.Usage)
 
 		case reflect.Float32:
-			flags.Float32(v.Name, 0, v.Usage)
+			flags.Float32P(v.Name, v.Short, 0, v.Usage)
 
 		case reflect.Float64:
-			flags.Float64(v.Name, 0, v.Usage)
+			flags.Float64P(v.Name, v.Short, 0, v.Usage)
 
 		case reflect.Int:
-			flags.Int(v.Name, 0, v.Usage)
+			flags.IntP(v.Name, v.Short, 0, v.Usage)
 
 		case reflect.Int16:
-			flags.Int16(v.Name, 0, v.Usage)
+			flags.Int16P(v.Name, v.Short, 0, v.Usage)
 
 		case reflect.Int32:
-			flags.Int(v.Name, 0, v.Usage)
+			flags.IntP(v.Name, v.Short, 0, v.Usage)
 
 		case reflect.Int64:
-			if v.Type.String() == "time.Duration" {
-				flags.Duration(v.Name, 0, v.Usage)
+			if valueType(v.Type) == durationType {
+				flags.DurationP(v.Name, v.Short, 0, v.Usage)
 			} else {
-				flags.Int64(v.Name, 0, v.Usage)
+				flags.Int64P(v.Name, v.Short, 0, v.Usage)
 			}
 
 		case reflect.Int8:
-			flags.Int8(v.Name, 0, v.Usage)
+			flags.Int8P(v.Name, v.Short, 0, v.Usage)
 
 		case reflect.Uint:
-			flags.Uint(v.Name, 0, v.Usage)
+			flags.UintP(v.Name, v.Short, 0, v.Usage)
 		case reflect.Uint32:
-			flags.Uint(v.Name, 0, v.Usage)
+			flags.UintP(v.Name, v.Short, 0, v.Usage)
 
 		case reflect.Uint64:
-			flags.Uint64P(v.Name, v.Usage, 0, v.Usage)
+			flags.Uint64P(v.Name, v.Short, 0, v.Usage)
 
 		case reflect.Uint8:
-			flags.Uint8(v.Name, 0, v.Usage)
+			flags.Uint8P(v.Name, v.Short, 0, v.Usage)
 
 		case reflect.Uint16:
-			flags.Uint16(v.Name, 0, v.Usage)
+			flags.Uint16P(v.Name, v.Short, 0, v.Usage)
 
 		case reflect.Slice:
-			switch v.Type.String() {
-			case "[]string":
-				flags.StringSlice(v.Name, []string{}, v.Usage)
-			case "[]uint8":
-				flags.BytesBase64(v.Name, []byte{}, "byte array in base64")
+			if v.Type.String() == "[]uint8" {
+				flags.BytesBase64P(v.Name, v.Short, []byte{}, "byte array in base64")
+			} else if c.isScalarSlice(v.Type) {
+				flags.VarP(newSliceValue(v.Type, c.parseValue), v.Name, v.Short, v.Usage)
 			}
-		}
 
-		if v.EnvVar != "" {
-			err := c.viper.BindEnv(v.Name, v.EnvVar)
-			if err != nil {
-				return err
+		case reflect.Map:
+			if c.isScalarMap(v.Type) {
+				flags.VarP(newMapValue(v.Type, c.parseValue), v.Name, v.Short, v.Usage)
 			}
 		}
 	}
 
-	err := flags.Parse(os.Args[1:])
-	// we use pflag.ExitOnError so we should not get error here
-	// but just in case I'll keep it
-	if err != nil {
-		return errors.Wrap(err, "failed to parse flags")
+	// flags show defaults in usage, values of unchanged flags are not used
+	for _, v := range fields {
+		if f := flags.Lookup(v.Name); f != nil && v.DefaultVal != "" && v.Arg == "" {
+			f.DefValue = v.FlagDefault
+		}
+	}
+	return nil
+}
+
+// parseFlags parses args and converts parse errors into FlagError
+func (c *ConfReader) parseFlags(flags *pflag.FlagSet, args []string, fields map[string]*flagInfo) error {
+	err := flags.Parse(args)
+	if err == nil {
+		return nil
+	}
+
+	if err == pflag.ErrHelp {
+		// help is printed by the flag set
+		if c.exitOnFlagError {
+			os.Exit(0)
+		}
+		return ErrHelp
+	}
+	flagErr := newFlagError(err)
+	for k, info := range fields {
+		if info.Arg == "" && (info.Name == flagErr.Flag || (info.Short != "" && info.Short == flagErr.Flag)) {
+			flagErr.Field = k
+		}
+	}
+	if c.exitOnFlagError {
+		fmt.Fprintln(c.helpWriter(), flagErr)
+		flags.Usage()
+		os.Exit(2)
 	}
-	for k, v := range tagsInfo {
-		f := flags.Lookup(v.Name)
-		if f != nil && f.Changed {
-			if v.Type.Kind() == reflect.Slice {
+	return flagErr
+}
+
+// flagsBinding sets values of flags changed in the flag sets and positional arguments
+func (c *ConfReader) flagsBinding(merged *viper.Viper, tagsInfo map[string]*flagInfo, trace sourceTrace, flagSets []*pflag.FlagSet, args []string) error {
+	for _, flags := range flagSets {
+		for _, k := range sortedKeys(tagsInfo) {
+			info := tagsInfo[k]
+			f := flags.Lookup(info.Name)
+			if f != nil && f.Changed && info.Arg == "" {
+				var val interface{}
+				switch {
+				case c.isCustomType(info.Type):
+					val = f.Value.(*textValue).value
 				// byte array should be in base64
-				if v.Type.String() == "[]uint8" {
+				case info.Type.String() == "[]uint8":
 					b, err := base64.StdEncoding.DecodeString(f.Value.String())
 					if err != nil {
-						return errors.Wrap(err, "failed to decode base64 value for flag: "+v.Name)
+						return errors.Wrap(err, "failed to decode base64 value for flag: "+info.Name)
 					}
-					c.viper.Set(k, b)
-				} else {
-					c.viper.Set(k, f.Value.(pflag.SliceValue).GetSlice())
+					val = b
+				case info.Type.Kind() == reflect.Slice:
+					val = f.Value.(*sliceValue).value
+				case info.Type.Kind() == reflect.Map:
+					val = f.Value.(*mapValue).value
+				default:
+					// the value is parsed from the flag, so provenance doesn't keep the pflag.Value that changes on re-parse
+					v, err := c.parseValue(f.Value.String(), valueType(info.Type))
+					if err != nil {
+						return errors.Wrapf(err, "failed to parse value of flag --%s", info.Name)
+					}
+					val = v
 				}
+				trace.add(k, Source{Kind: SourceFlag, Name: "--" + info.Name, Value: val})
 
-			} else {
-				c.viper.Set(k, f.Value)
+				if m, ok := val.(map[string]interface{}); ok {
+					// keys set by flags are added to keys from other sources
+					val = mergeMap(getValue(merged, k, info), m)
+				}
+				if err := setValue(merged, k, info, val); err != nil {
+					return errors.Wrapf(err, "flag --%s", info.Name)
+				}
 			}
 		}
+	}
+
+	return c.argsBinding(merged, tagsInfo, trace, args)
+}
 
+// checkShorthands checks that shorthands from `short` tags are single ASCII characters, which pflag requires, and are not used by several flags
+func checkShorthands(tagsInfo map[string]*flagInfo) error {
+	keys := make([]string, 0, len(tagsInfo))
+	for k := range tagsInfo {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
 
+	used := map[string]string{"h": "help"}
+	for _, k := range keys {
+		info := tagsInfo[k]
+		if info.Short == "" {
+			continue
+		}
+		if len(info.Short) != 1 || info.Short[0] >= utf8.RuneSelf || info.Short == "-" {
+			return errors.Errorf("shorthand %q of flag --%s must be a single ASCII character", info.Short, info.Name)
+		}
+		if other, ok := used[info.Short]; ok {
+			return errors.Errorf("shorthand -%s of flag --%s is already used by flag --%s", info.Short, info.Name, other)
+		}
+		used[info.Short] = info.Name
+	}
 	return nil
 }
 
+// commandLine returns program name and arguments to parse flags from
+func (c *ConfReader) commandLine() (string, []string) {
+	if c.args != nil {
+		return c.configName, c.args
+	}
+	return os.Args[0], os.Args[1:]
+}
+
 type flagInfo struct {
 	Name       string
 	Type       reflect.Type
 	DefaultVal string
-	EnvVar     string
-	Usage      string
+	// FlagDefault is DefaultVal formatted the way the flag prints its value
+	FlagDefault string
+	// Short is a one letter shorthand of the flag
+	Short  string
+	EnvVar string
+	Usage  string
+	// Required is true if the field has `required` validation rule
+	Required bool
+	// Arg is a position of the argument that sets the field or "rest", the field doesn't have a flag if it is set
+	Arg string
+	// Cmd is a name of the command the field belongs to, it is empty for global fields
+	Cmd   string
+	Index []int
+	// Elem is set for fields of elements of slices and maps, they are not in the config struct and Index is nil
+	Elem *elemRef
+}
+
+// parseDefaults checks that `default` tags could be parsed into types of their fields and formats them for flags
+func (c *ConfReader) parseDefaults(tagsInfo map[string]*flagInfo) error {
+	for k, info := range tagsInfo {
+		if info.DefaultVal == "" {
+			continue
+		}
+		val, err := c.formatDefault(info)
+		if err != nil {
+			return errors.Errorf("invalid default value %q of field %s of type %s: %s", info.DefaultVal, k, info.Type, err)
+		}
+		info.FlagDefault = val
+	}
+	return nil
 }
 
-func (c *ConfReader) dumpStruct(t reflect.Type, path string, res map[string]*flagInfo) map[string]*flagInfo {
+// dumpStruct collects leaf fields of the struct type. index is a path of field indexes from the root struct.
+func (c *ConfReader) dumpStruct(t reflect.Type, path string, index []int, res map[string]*flagInfo) map[string]*flagInfo {
 	if c.Verbose {
 		log.Printf("%s: %s", path, t.Name())
 	}
 	switch t.Kind() {
 	case reflect.Ptr:
 		originalValue := t.Elem()
-		res = c.dumpStruct(originalValue, path, res)
+		res = c.dumpStruct(originalValue, path, index, res)
 
 	// If it is a struct we translate each field
 	case reflect.Struct:
 		for i := 0; i < t.NumField(); i++ {
 			f := t.Field(i)
+			fieldIndex := append(append([]int{}, index...), i)
 
-			if f.Type.Kind() != reflect.Struct &&
-				f.Type.Kind() != reflect.Ptr &&
-				f.Type.Kind() != reflect.Chan &&
-				f.Type.Kind() != reflect.Func &&
-				f.Type.Kind() != reflect.Interface &&
-				f.Type.Kind() != reflect.UnsafePointer {
+			if !f.IsExported() && !f.Anonymous {
+				// unexported fields can't be set
+				continue
+			}
+			if f.Tag.Get("mapstructure") == "-" {
+				// such fields are not decoded, the app sets them itself
+				continue
+			}
+			if f.Tag.Get("cmd") != "" {
+				// fields of commands are collected by dumpCommand
+				continue
+			}
+
+			// types with custom parsers and pointers to scalars are leaves even if they are structs or pointers
+			if c.isCustomType(f.Type) || isScalarPtr(f.Type) ||
+				(f.Type.Kind() != reflect.Struct &&
+					f.Type.Kind() != reflect.Ptr &&
+					f.Type.Kind() != reflect.Chan &&
+					f.Type.Kind() != reflect.Func &&
+					f.Type.Kind() != reflect.Interface &&
+					f.Type.Kind() != reflect.UnsafePointer) {
 
 				// do we have flag name override ?
 				flagVal := f.Tag.Get("flag")
 				envVar := f.Tag.Get("envvar")
 				usage := f.Tag.Get("usage")
+				short := f.Tag.Get("short")
+				arg := f.Tag.Get("arg")
+				required := hasRule(f.Tag.Get("validate"), "required")
 
 				fieldPath := strings.TrimPrefix(strings.ToLower(path+"."+f.Name), ".")
 				if flagVal != "" {
@@ -263,25 +775,33 @@ func (c *ConfReader) dumpStruct(t reflect.Type, path string, res map[string]*fla
 						Name:       flagVal,
 						Type:       f.Type,
 						DefaultVal: f.Tag.Get("default"),
+						Short:      short,
 						EnvVar:     envVar,
 						Usage:      usage,
+						Required:   required,
+						Arg:        arg,
+						Index:      fieldIndex,
 					}
 				} else {
 					res[fieldPath] = &flagInfo{
 						Name:       fieldPath,
 						Type:       f.Type,
 						DefaultVal: f.Tag.Get("default"),
+						Short:      short,
 						EnvVar:     envVar,
 						Usage:      usage,
+						Required:   required,
+						Arg:        arg,
+						Index:      fieldIndex,
 					}
 				}
 
 			} else if f.Type.Kind() == reflect.Struct || f.Type.Kind() == reflect.Ptr {
 				val := f.Tag.Get("mapstructure")
 				if strings.Contains(val, "squash") {
-					res = c.dumpStruct(f.Type, path, res)
+					res = c.dumpStruct(f.Type, path, fieldIndex, res)
 				} else {
-					res = c.dumpStruct(f.Type, path+"."+f.Name, res)
+					res = c.dumpStruct(f.Type, path+"."+f.Name, fieldIndex, res)
 				}
 			}
 		}
@@ -292,6 +812,16 @@ func (c *ConfReader) dumpStruct(t reflect.Type, path string, res map[string]*fla
 	return res
 }
 
+// hasRule tells if the `validate` tag contains the rule
+func hasRule(tag string, rule string) bool {
+	for _, r := range strings.Split(tag, ",") {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *ConfReader) WithSearchDirs(s ...string) *ConfReader {
 	c.configDirs = s
 	return c
@@ -304,25 +834,48 @@ func (c *ConfReader) WithPrefix(prefix string) *ConfReader {
 	return c
 }
 
-// Watch watches for config changes and reloads config. This method should be called after Read() to make sure that ConfReader konws which struct to reload.
-// Returns a mutex that can be used to synchronize access to the config.
-// If you care about thread safety, call RLock() on the mutex while accessing the config and the RUnlock().
-// This will ensure that the config is not reloaded while you are accessing it.
-func (c *ConfReader) Watch() *sync.RWMutex {
-	if c.configStruct == nil {
-		panic("ConfReader: config struct is not set. Call Read before Watch")
-	}
-	rwmutex := &sync.RWMutex{}
-
-	c.viper.WatchConfig()
-	c.viper.OnConfigChange(func(e fsnotify.Event) {
-		rwmutex.Lock()
-		defer rwmutex.Unlock()
-		err := c.Read(c.configStruct)
-		if err != nil {
-			log.Printf("failed to reload config: %s\n", err)
-		}
+// WithArgs sets command line arguments to parse flags from instead of os.Args.
+// Arguments should not include the program name, e.g. []string{"--db.host", "localhost"}.
+func (c *ConfReader) WithArgs(args []string) *ConfReader {
+	if args == nil {
+		args = []string{}
+	}
+	c.args = args
+	return c
+}
 
+// WithEnv sets environment variables to read config from instead of process environment.
+func (c *ConfReader) WithEnv(env map[string]string) *ConfReader {
+	c.WithEnvLookup(func(name string) (string, bool) {
+		val, ok := env[name]
+		return val, ok
 	})
-	return rwmutex
+	for name := range env {
+		c.envNames = append(c.envNames, name)
+	}
+	return c
+}
+
+// WithEnvLookup sets a function that is used to look up environment variables instead of os.LookupEnv.
+// Env vars can't be listed in that case, so keys of maps of structs are only set by env vars if the config file has them.
+func (c *ConfReader) WithEnvLookup(lookup func(string) (string, bool)) *ConfReader {
+	c.envLookup = lookup
+	c.envNames = nil
+	return c
+}
+
+// WithFS sets a filesystem to search the config file in instead of OS filesystem.
+// Search dirs are relative to the root of fsys, the root itself is searched if no search dirs are set.
+// Config file from fsys can not be watched.
+func (c *ConfReader) WithFS(fsys fs.FS) *ConfReader {
+	c.fs = fsys
+	return c
+}
+
+// WithExitOnFlagError makes Read print the error with usage and exit the process with status 2
+// if command line flags could not be parsed, and exit with status 0 after printing help requested by --help.
+// By default Read returns *FlagError or ErrHelp instead.
+func (c *ConfReader) WithExitOnFlagError() *ConfReader {
+	c.exitOnFlagError = true
+	return c
 }
@@ -5,6 +5,7 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/num30/config/lib"
@@ -219,7 +220,7 @@ func Test_dumpStruct(t *testing.T) {
 		Verbose: true,
 	}
 
-	c.dumpStruct(reflect.TypeOf(dmParent{}), "", m)
+	c.dumpStruct(reflect.TypeOf(dmParent{}), "", nil, m)
 
 	if assert.NotNil(t, m["verbose"]) {
 		assert.Equal(t, "verbose", m["verbose"].Name)
@@ -259,7 +260,7 @@ func Test_Validation(t *testing.T) {
 		resetFlags()
 		err := reader.Read(cf)
 		if assert.Error(t, err) {
-			assert.Equal(t, "validation error: Key: 'ValidationConfig.Host' Error:Field validation for 'Host' failed on the 'required' tag", err.Error())
+			assert.Equal(t, `validation error: host: failed on "required" (env HOST, flag --host)`, err.Error())
 		}
 	})
 
@@ -305,6 +306,54 @@ func Test_SetDefault(t *testing.T) {
 		}
 
 	})
+
+	t.Run("flagDefaults", func(t *testing.T) {
+		type FlagDefaults struct {
+			Port     int               `default:"5432"`
+			Enabled  bool              `default:"true"`
+			Ratio    float64           `default:"0.5"`
+			Timeout  time.Duration     `default:"1m"`
+			Hosts    []string          `default:"[\"a\",\"b\"]"`
+			Labels   map[string]string `default:"{\"k\":\"v\"}"`
+			Name     string            `default:"test"`
+			NoTagVal int
+		}
+
+		reader := NewConfReader("flag-defaults")
+		m := reader.dumpStruct(reflect.TypeOf(FlagDefaults{}), "", nil, map[string]*flagInfo{})
+		if assert.NoError(t, reader.parseDefaults(m)) {
+			assert.Equal(t, "5432", m["port"].FlagDefault)
+			assert.Equal(t, "true", m["enabled"].FlagDefault)
+			assert.Equal(t, "0.5", m["ratio"].FlagDefault)
+			assert.Equal(t, "1m0s", m["timeout"].FlagDefault)
+			assert.Equal(t, "[a,b]", m["hosts"].FlagDefault)
+			assert.Equal(t, "k=v", m["labels"].FlagDefault)
+			assert.Equal(t, "test", m["name"].FlagDefault)
+			assert.Equal(t, "", m["notagval"].FlagDefault)
+		}
+
+		cf := &FlagDefaults{}
+		err := reader.WithArgs([]string{}).WithEnv(nil).WithFS(fstest.MapFS{}).Read(cf)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 5432, cf.Port)
+			assert.Equal(t, time.Minute, cf.Timeout)
+		}
+	})
+
+	t.Run("invalidDefault", func(t *testing.T) {
+		type InvalidDefault struct {
+			Db struct {
+				Port int `default:"localhost"`
+			}
+		}
+
+		cf := &InvalidDefault{}
+		err := NewConfReader("invalid-default").WithArgs([]string{}).WithEnv(nil).WithFS(fstest.MapFS{}).Read(cf)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "failed to set default values")
+			assert.Contains(t, err.Error(), `invalid default value "localhost" of field db.port of type int`)
+		}
+	})
 }
 
 type AllTypes struct {
@@ -449,3 +498,223 @@ func Test_InputStructErrors(t *testing.T) {
 func Test_ErrorHandling(t *testing.T) {
 
 }
+
+type ExplainConfig struct {
+	Host    string `default:"localhost"`
+	Port    int    `default:"5432"`
+	Name    string
+	User    string `envvar:"EXPLAIN_USER"`
+	Timeout time.Duration
+	Workers int
+}
+
+func Test_Explain(t *testing.T) {
+	fsys := fstest.MapFS{"explain.yaml": {Data: []byte("host: fromfile\nport: 1000\n")}}
+	cfg := &ExplainConfig{}
+	reader := NewConfReader("explain").WithFS(fsys).WithArgs([]string{"--host", "fromflag", "--workers", "5"}).
+		WithEnv(map[string]string{"PORT": "2000", "EXPLAIN_USER": "admin"})
+	err := reader.Read(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	file := "explain.yaml"
+	res := reader.Explain()
+	if assert.Len(t, res, 6) {
+		assert.Equal(t, "host", res[0].Field)
+		assert.Equal(t, Source{Kind: SourceFlag, Name: "--host", Value: "fromflag"}, res[0].Source)
+		assert.Equal(t, []Source{
+			{Kind: SourceFile, Name: file, Value: "fromfile"},
+			{Kind: SourceDefault, Value: "localhost"},
+		}, res[0].Overridden)
+	}
+
+	port, ok := reader.Provenance("port")
+	if assert.True(t, ok) {
+		assert.Equal(t, Source{Kind: SourceEnv, Name: "PORT", Value: "2000"}, port.Source)
+		assert.Equal(t, `port: env PORT "2000", overrides file `+file+` "1000", overrides default "5432"`, port.String())
+	}
+
+	user, _ := reader.Provenance("user")
+	assert.Equal(t, Source{Kind: SourceEnv, Name: "EXPLAIN_USER", Value: "admin"}, user.Source)
+
+	workers, _ := reader.Provenance("workers")
+	assert.Equal(t, Source{Kind: SourceFlag, Name: "--workers", Value: 5}, workers.Source)
+
+	name, _ := reader.Provenance("name")
+	assert.Equal(t, SourceNone, name.Source.Kind)
+	assert.Empty(t, name.Overridden)
+}
+
+func Test_Load(t *testing.T) {
+	t.Run("loadsConfig", func(t *testing.T) {
+		resetFlags()
+		os.Args = []string{"app", "--app.overriddenbyarg", "fromArg"}
+		nc, err := Load[FullConfig]("myapp", WithSearchDirs("testdata"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, "valFromConf", nc.App.FromConfig)
+			assert.Equal(t, "fromArg", nc.App.OverriddenByArg)
+			assert.Equal(t, true, nc.Verbose)
+		}
+	})
+
+	t.Run("withPrefix", func(t *testing.T) {
+		resetFlags()
+		os.Setenv("LOADPREFIX_APP_FROMENVVAR", "valFromEnvVar")
+		defer os.Unsetenv("LOADPREFIX_APP_FROMENVVAR")
+		nc, err := Load[FullConfig]("myapp", WithPrefix("LOADPREFIX"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, "valFromEnvVar", nc.App.FromEnvVar)
+		}
+	})
+
+	t.Run("validationFails", func(t *testing.T) {
+		resetFlags()
+		nc, err := Load[ValidationConfig]("myapp")
+		assert.Error(t, err)
+		assert.Nil(t, nc)
+	})
+
+	t.Run("mustLoadPanics", func(t *testing.T) {
+		resetFlags()
+		assert.Panics(t, func() {
+			MustLoad[ValidationConfig]("myapp")
+		})
+	})
+}
+
+func Test_Hermetic(t *testing.T) {
+	fsys := fstest.MapFS{
+		"etc/myapp/myapp.yaml": {Data: []byte("app:\n  fromConfig: valFromFS\n  overriddenByEvnVar: SHOULD_NOT_APPEAR\n")},
+		"myapp.json":           {Data: []byte(`{"app": {"fromConfig": "valFromRoot"}}`)},
+		"bad/myapp.yaml":       {Data: []byte("app: [")},
+	}
+
+	t.Run("allSources", func(t *testing.T) {
+		t.Parallel()
+		nc := &FullConfig{}
+		err := NewConfReader("myapp").
+			WithFS(fsys).
+			WithSearchDirs("etc/myapp").
+			WithEnv(map[string]string{"APP_OVERRIDDENBYEVNVAR": "fromEnv", "CUSTOM_ENV_VAR": "custom"}).
+			WithArgs([]string{"--id", "10", "--verbose"}).
+			Read(nc)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "valFromFS", nc.App.FromConfig)
+			assert.Equal(t, "fromEnv", nc.App.OverriddenByEvnVar)
+			assert.Equal(t, "custom", nc.App.EnvVarName)
+			assert.Equal(t, "10", nc.App.Id)
+			assert.Equal(t, true, nc.Verbose)
+		}
+	})
+
+	t.Run("rootOfFS", func(t *testing.T) {
+		t.Parallel()
+		nc := &FullConfig{}
+		err := NewConfReader("myapp").WithFS(fsys).WithArgs(nil).WithEnv(nil).Read(nc)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "valFromRoot", nc.App.FromConfig)
+		}
+	})
+
+	t.Run("envLookup", func(t *testing.T) {
+		t.Parallel()
+		nc := &FullConfig{}
+		err := NewConfReader("myapp").WithFS(fstest.MapFS{}).WithArgs(nil).
+			WithEnvLookup(func(name string) (string, bool) {
+				if name == "APP_FROMCONFIG" {
+					return "fromLookup", true
+				}
+				return "", false
+			}).
+			Read(nc)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "fromLookup", nc.App.FromConfig)
+		}
+	})
+
+	t.Run("malformedFile", func(t *testing.T) {
+		t.Parallel()
+		nc := &FullConfig{}
+		err := NewConfReader("myapp").WithFS(fsys).WithSearchDirs("bad").WithArgs(nil).WithEnv(nil).Read(nc)
+		assert.Error(t, err)
+	})
+}
+
+type ShortFlagsConfig struct {
+	Verbose bool `short:"v"`
+	Server  struct {
+		Port int    `short:"p"`
+		Host string `short:"H"`
+	}
+}
+
+func Test_ShortFlags(t *testing.T) {
+	t.Run("set", func(t *testing.T) {
+		cf := &ShortFlagsConfig{}
+		err := NewConfReader("short-flags").WithArgs([]string{"-vp", "8080", "-H=example.com"}).WithEnv(nil).Read(cf)
+		if assert.NoError(t, err) {
+			assert.True(t, cf.Verbose)
+			assert.Equal(t, 8080, cf.Server.Port)
+			assert.Equal(t, "example.com", cf.Server.Host)
+		}
+	})
+
+	t.Run("longNameStillWorks", func(t *testing.T) {
+		cf := &ShortFlagsConfig{}
+		err := NewConfReader("short-flags").WithArgs([]string{"--server.port", "9090"}).WithEnv(nil).Read(cf)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 9090, cf.Server.Port)
+		}
+	})
+
+	t.Run("missingValue", func(t *testing.T) {
+		err := NewConfReader("short-flags").WithArgs([]string{"-p"}).WithEnv(nil).Read(&ShortFlagsConfig{})
+		var flagErr *FlagError
+		if assert.ErrorAs(t, err, &flagErr) {
+			assert.Equal(t, "p", flagErr.Flag)
+			assert.Equal(t, "server.port", flagErr.Field)
+		}
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		type Duplicate struct {
+			Verbose bool `short:"v"`
+			Version bool `short:"v"`
+		}
+		err := NewConfReader("short-flags").WithArgs([]string{}).WithEnv(nil).Read(&Duplicate{})
+		if assert.Error(t, err) {
+			assert.Equal(t, "shorthand -v of flag --version is already used by flag --verbose", err.Error())
+		}
+	})
+
+	t.Run("reservedForHelp", func(t *testing.T) {
+		type Help struct {
+			Host string `short:"h"`
+		}
+		err := NewConfReader("short-flags").WithArgs([]string{}).WithEnv(nil).Read(&Help{})
+		if assert.Error(t, err) {
+			assert.Equal(t, "shorthand -h of flag --host is already used by flag --help", err.Error())
+		}
+	})
+
+	t.Run("tooLong", func(t *testing.T) {
+		type TooLong struct {
+			Verbose bool `short:"vv"`
+		}
+		err := NewConfReader("short-flags").WithArgs([]string{}).WithEnv(nil).Read(&TooLong{})
+		if assert.Error(t, err) {
+			assert.Equal(t, `shorthand "vv" of flag --verbose must be a single ASCII character`, err.Error())
+		}
+	})
+
+	t.Run("notASCII", func(t *testing.T) {
+		type NotASCII struct {
+			Verbose bool `short:"é"`
+		}
+		err := NewConfReader("short-flags").WithArgs([]string{}).WithEnv(nil).Read(&NotASCII{})
+		if assert.Error(t, err) {
+			assert.Equal(t, `shorthand "é" of flag --verbose must be a single ASCII character`, err.Error())
+		}
+	})
+}
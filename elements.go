@@ -0,0 +1,361 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// elemRef points to a field of an element of a slice or map of structs, or to a value of a map of scalars.
+// Such fields are not known before reading, they are added for elements addressed by the config file, env vars and flags,
+// e.g. UPSTREAMS_0_HOST and --upstreams.0.host for the field "host" of the first element of Upstreams []Upstream.
+type elemRef struct {
+	// collection is the key of the slice or map field
+	collection string
+	slice      bool
+	// path is an index or a map key followed by the path of the field in the element, like ["0", "host"]
+	path []string
+}
+
+// get returns the value of the element field in the value of the collection
+func (e *elemRef) get(collection interface{}) (interface{}, bool) {
+	v := collection
+	for i, p := range e.path {
+		if i == 0 && e.slice {
+			items := cast.ToSlice(v)
+			n, err := strconv.Atoi(p)
+			if err != nil || n >= len(items) {
+				return nil, false
+			}
+			v = items[n]
+			continue
+		}
+		m, err := cast.ToStringMapE(v)
+		if err != nil {
+			return nil, false
+		}
+		k, ok := matchKey(m, p)
+		if !ok {
+			return nil, false
+		}
+		v = m[k]
+	}
+	return v, true
+}
+
+// set returns a copy of the collection with the element field set to val. An element is appended to a slice
+// if the index is the length of the slice, greater indexes are out of range.
+func (e *elemRef) set(collection interface{}, val interface{}) (interface{}, error) {
+	if !e.slice {
+		return setPath(collection, e.path, val), nil
+	}
+	items := append([]interface{}{}, cast.ToSlice(collection)...)
+	n, _ := strconv.Atoi(e.path[0])
+	if n > len(items) {
+		return nil, fmt.Errorf("index %d is out of range, %s has %d elements", n, e.collection, len(items))
+	}
+	if n == len(items) {
+		items = append(items, map[string]interface{}{})
+	}
+	items[n] = setPath(items[n], e.path[1:], val)
+	return items, nil
+}
+
+// index returns the index of the slice element, or -1 for map elements
+func (e *elemRef) index() int {
+	if !e.slice {
+		return -1
+	}
+	n, _ := strconv.Atoi(e.path[0])
+	return n
+}
+
+// setPath returns a copy of the map m with the value set by the path of keys
+func setPath(m interface{}, path []string, val interface{}) interface{} {
+	if len(path) == 0 {
+		return val
+	}
+	res := map[string]interface{}{}
+	for k, v := range cast.ToStringMap(m) {
+		res[k] = v
+	}
+	k, ok := matchKey(res, path[0])
+	if !ok {
+		k = path[0]
+	}
+	res[k] = setPath(res[k], path[1:], val)
+	return res
+}
+
+// matchKey finds the key in the map ignoring case. Keys of maps in slices keep their case from the config file,
+// while field keys are lower case.
+func matchKey(m map[string]interface{}, key string) (string, bool) {
+	if _, ok := m[key]; ok {
+		return key, true
+	}
+	for k := range m {
+		if strings.EqualFold(k, key) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// getValue returns the current value of the field in merged
+func getValue(merged *viper.Viper, k string, info *flagInfo) interface{} {
+	if info.Elem == nil {
+		return merged.Get(k)
+	}
+	v, _ := info.Elem.get(merged.Get(info.Elem.collection))
+	return v
+}
+
+// setValue sets the value of the field in merged. Fields of elements are set in a copy of the collection,
+// so other elements and fields keep values from the other sources.
+func setValue(merged *viper.Viper, k string, info *flagInfo, val interface{}) error {
+	if info.Elem == nil {
+		merged.Set(k, val)
+		return nil
+	}
+	collection, err := info.Elem.set(merged.Get(info.Elem.collection), val)
+	if err != nil {
+		return err
+	}
+	merged.Set(info.Elem.collection, collection)
+	return nil
+}
+
+// sortedKeys returns keys of the fields sorted by path. Elements of the same slice are sorted by index,
+// so they are set in order and every element is appended right after the previous one.
+func sortedKeys(fields map[string]*flagInfo) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := fields[keys[i]].Elem, fields[keys[j]].Elem
+		if a != nil && b != nil && a.slice && b.slice && a.collection == b.collection && a.index() != b.index() {
+			return a.index() < b.index()
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// elemFields returns fields of the elements of slices and maps of structs. Keys are relative to the element, like "host".
+// It returns nil for other types.
+func (c *ConfReader) elemFields(t reflect.Type) map[string]*flagInfo {
+	if c.isCustomType(t) || (t.Kind() != reflect.Slice && (t.Kind() != reflect.Map || t.Key().Kind() != reflect.String)) {
+		return nil
+	}
+	e := t.Elem()
+	if e.Kind() == reflect.Ptr {
+		e = e.Elem()
+	}
+	if e.Kind() != reflect.Struct || c.isCustomType(e) {
+		return nil
+	}
+	fields := c.dumpStruct(e, "", nil, map[string]*flagInfo{})
+	for _, info := range fields {
+		// names from tags would be the same for every element
+		info.Name, info.EnvVar, info.Short, info.Arg = "", "", "", ""
+	}
+	return fields
+}
+
+// addElemField adds a field of the element of the collection k. elemKey is an index or a map key,
+// leaf is the field of the element or nil for values of maps of scalars.
+func addElemField(fields map[string]*flagInfo, k string, coll *flagInfo, elemKey string, leafKey string, leaf *flagInfo) {
+	key := k + "." + strings.ToLower(elemKey)
+	name := coll.Name + "." + elemKey
+	path := []string{strings.ToLower(elemKey)}
+	info := &flagInfo{Type: coll.Type.Elem(), Cmd: coll.Cmd}
+	if leaf != nil {
+		key += "." + leafKey
+		name += "." + leafKey
+		path = append(path, strings.Split(leafKey, ".")...)
+		info.Type, info.Usage = leaf.Type, leaf.Usage
+	}
+	if _, ok := fields[key]; ok {
+		return
+	}
+	info.Name = name
+	info.Elem = &elemRef{collection: k, slice: coll.Type.Kind() == reflect.Slice, path: path}
+	fields[key] = info
+}
+
+// addElemFlags adds fields of elements of slices and maps of structs which flags are given in args, e.g. --upstreams.0.host
+func (c *ConfReader) addElemFlags(fields map[string]*flagInfo, args []string) {
+	collections := c.collections(fields)
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "--") {
+			continue
+		}
+		name := strings.SplitN(strings.TrimPrefix(arg, "--"), "=", 2)[0]
+		for _, k := range collections {
+			coll := fields[k]
+			leaves := c.elemFields(coll.Type)
+			if leaves == nil || !strings.HasPrefix(name, coll.Name+".") {
+				continue
+			}
+			parts := strings.SplitN(strings.TrimPrefix(name, coll.Name+"."), ".", 2)
+			if len(parts) != 2 || leaves[parts[1]] == nil || !isElemKey(coll, parts[0]) {
+				continue
+			}
+			addElemField(fields, k, coll, parts[0], parts[1], leaves[parts[1]])
+		}
+	}
+}
+
+// addElemEnv adds fields of elements that could be set by env vars: elements from the config file, indexes of slices
+// that follow them while env vars are set for them and map keys found in environment.
+// Values of maps of scalars are only added for keys from the config file.
+// It returns *EnvError if an env var sets a field of a slice element past the elements that are added.
+func (c *ConfReader) addElemEnv(fields map[string]*flagInfo) error {
+	var environ []string
+	for _, k := range c.collections(fields) {
+		coll := fields[k]
+		leaves := c.elemFields(coll.Type)
+		if leaves == nil && !c.isScalarMap(coll.Type) {
+			continue
+		}
+		add := func(elemKey string) {
+			if leaves == nil {
+				addElemField(fields, k, coll, elemKey, "", nil)
+				return
+			}
+			for leafKey, leaf := range leaves {
+				addElemField(fields, k, coll, elemKey, leafKey, leaf)
+			}
+		}
+
+		file := c.viper.Get(k)
+		if coll.Type.Kind() == reflect.Slice {
+			n := len(cast.ToSlice(file))
+			i := 0
+			for ; i < n || c.elemEnvSet(k, strconv.Itoa(i), leaves); i++ {
+				add(strconv.Itoa(i))
+			}
+			if environ == nil {
+				environ = c.environ()
+			}
+			if err := c.checkElemEnvIndexes(k, i, leaves, environ); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for key := range cast.ToStringMap(file) {
+			add(key)
+		}
+		if leaves == nil {
+			continue
+		}
+		if environ == nil {
+			environ = c.environ()
+		}
+		prefix := c.envVarNames(k, &flagInfo{})[0] + "_"
+		for _, name := range environ {
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			// the longest matching field wins, so LIMITS_QUOTA doesn't make a key ending with LIMITS for the field QUOTA
+			rest := strings.TrimPrefix(name, prefix)
+			key := ""
+			for leafKey := range leaves {
+				suffix := "_" + strings.ToUpper(strings.ReplaceAll(leafKey, ".", "_"))
+				if len(rest) > len(suffix) && strings.HasSuffix(rest, suffix) && (key == "" || len(rest)-len(suffix) < len(key)) {
+					key = rest[:len(rest)-len(suffix)]
+				}
+			}
+			if key != "" {
+				add(strings.ToLower(key))
+			}
+		}
+	}
+	return nil
+}
+
+// checkElemEnvIndexes returns *EnvError for the first env var that sets a field of an element of the slice k
+// with an index that is not less than n, the number of elements. Indexes can't skip elements, like flags can't.
+func (c *ConfReader) checkElemEnvIndexes(k string, n int, leaves map[string]*flagInfo, environ []string) error {
+	prefix := c.envVarNames(k, &flagInfo{})[0] + "_"
+	names := append([]string{}, environ...)
+	sort.Strings(names)
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(name, prefix), "_", 2)
+		i, err := strconv.Atoi(parts[0])
+		if err != nil || i < n || len(parts) != 2 || strconv.Itoa(i) != parts[0] {
+			continue
+		}
+		for leafKey := range leaves {
+			if parts[1] != strings.ToUpper(strings.ReplaceAll(leafKey, ".", "_")) {
+				continue
+			}
+			if val, ok := c.lookupEnv(name); ok && val != "" {
+				return &EnvError{Var: name, Field: k + "." + parts[0] + "." + leafKey, Value: val,
+					Err: fmt.Errorf("index %d is out of range, %s has %d elements", i, k, n)}
+			}
+		}
+	}
+	return nil
+}
+
+// elemEnvSet tells if an env var is set for any field of the element
+func (c *ConfReader) elemEnvSet(k string, elemKey string, leaves map[string]*flagInfo) bool {
+	for leafKey := range leaves {
+		if val, ok := c.lookupEnv(c.envVarNames(k+"."+elemKey+"."+leafKey, &flagInfo{})[0]); ok && val != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// collections returns sorted keys of slice and map fields
+func (c *ConfReader) collections(fields map[string]*flagInfo) []string {
+	var res []string
+	for k, info := range fields {
+		kind := info.Type.Kind()
+		if info.Elem == nil && info.Arg == "" && (kind == reflect.Slice || kind == reflect.Map) {
+			res = append(res, k)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// isElemKey tells if the key could address an element of the collection: slices are addressed by non-negative indexes
+func isElemKey(coll *flagInfo, key string) bool {
+	if key == "" {
+		return false
+	}
+	if coll.Type.Kind() != reflect.Slice {
+		return true
+	}
+	n, err := strconv.Atoi(key)
+	return err == nil && n >= 0 && strconv.Itoa(n) == key
+}
+
+// environ returns names of environment variables. Names can't be listed if env vars are looked up by WithEnvLookup.
+func (c *ConfReader) environ() []string {
+	if c.envLookup != nil {
+		return c.envNames
+	}
+	var res []string
+	for _, kv := range os.Environ() {
+		res = append(res, strings.SplitN(kv, "=", 2)[0])
+	}
+	return res
+}
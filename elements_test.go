@@ -0,0 +1,144 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Upstream struct {
+	Host string
+	Port int
+}
+
+type TenantConf struct {
+	Quota  int
+	Limits struct {
+		Cpu   float64
+		Quota int
+	}
+}
+
+type ElemsConfig struct {
+	Upstreams []Upstream
+	Tenants   map[string]TenantConf
+	Labels    map[string]string
+}
+
+const elemsFile = `
+upstreams:
+  - Host: a.local
+    port: 8080
+tenants:
+  acme:
+    quota: 5
+labels:
+  team: core
+`
+
+func Test_Elements(t *testing.T) {
+	fsys := fstest.MapFS{"elems.yaml": {Data: []byte(elemsFile)}}
+
+	t.Run("env", func(t *testing.T) {
+		cf := &ElemsConfig{}
+		err := NewConfReader("elems").WithArgs([]string{}).WithFS(fsys).WithEnv(map[string]string{
+			"UPSTREAMS_0_PORT":          "9090",
+			"UPSTREAMS_1_HOST":          "b.local",
+			"TENANTS_ACME_LIMITS_CPU":   "1.5",
+			"TENANTS_BETA_QUOTA":        "7",
+			"LABELS_TEAM":               "infra",
+			"TENANTS_GAMMA_UNKNOWNQUOT": "1",
+		}).Read(cf)
+		if assert.NoError(t, err) {
+			assert.Equal(t, []Upstream{{Host: "a.local", Port: 9090}, {Host: "b.local"}}, cf.Upstreams)
+			assert.Equal(t, 5, cf.Tenants["acme"].Quota)
+			assert.Equal(t, 1.5, cf.Tenants["acme"].Limits.Cpu)
+			assert.Equal(t, 7, cf.Tenants["beta"].Quota)
+			assert.Len(t, cf.Tenants, 2)
+			assert.Equal(t, map[string]string{"team": "infra"}, cf.Labels)
+		}
+	})
+
+	t.Run("flags", func(t *testing.T) {
+		cf := &ElemsConfig{}
+		reader := NewConfReader("elems").WithFS(fsys).
+			WithArgs([]string{"--upstreams.0.host=c.local", "--upstreams.1.port", "81", "--tenants.acme.quota", "10"}).
+			WithEnv(map[string]string{"UPSTREAMS_0_HOST": "b.local", "UPSTREAMS_0_PORT": "9090"})
+		if !assert.NoError(t, reader.Read(cf)) {
+			return
+		}
+		assert.Equal(t, []Upstream{{Host: "c.local", Port: 9090}, {Port: 81}}, cf.Upstreams)
+		assert.Equal(t, 10, cf.Tenants["acme"].Quota)
+
+		p, ok := reader.Provenance("upstreams.0.host")
+		if assert.True(t, ok) {
+			assert.Equal(t, `upstreams.0.host: flag --upstreams.0.host "c.local", overrides env UPSTREAMS_0_HOST "b.local", overrides file elems.yaml "a.local"`, p.String())
+		}
+	})
+
+	t.Run("nestedFieldSuffix", func(t *testing.T) {
+		// TENANTS_ACME_LIMITS_QUOTA sets limits.quota of acme, not quota of "acme_limits"
+		cf := &ElemsConfig{}
+		err := NewConfReader("elems").WithArgs([]string{}).WithFS(fstest.MapFS{}).
+			WithEnv(map[string]string{"TENANTS_ACME_LIMITS_QUOTA": "5"}).Read(cf)
+		if assert.NoError(t, err) {
+			assert.Len(t, cf.Tenants, 1)
+			assert.Equal(t, 5, cf.Tenants["acme"].Limits.Quota)
+			assert.Equal(t, 0, cf.Tenants["acme"].Quota)
+		}
+	})
+
+	t.Run("appendByFlags", func(t *testing.T) {
+		args := []string{"--upstreams.0.host", "h0"}
+		for i := 1; i <= 10; i++ {
+			args = append(args, fmt.Sprintf("--upstreams.%d.port=%d", i, i))
+		}
+		cf := &ElemsConfig{}
+		err := NewConfReader("elems").WithArgs(args).WithFS(fstest.MapFS{}).WithEnv(nil).Read(cf)
+		if assert.NoError(t, err) && assert.Len(t, cf.Upstreams, 11) {
+			assert.Equal(t, Upstream{Host: "h0"}, cf.Upstreams[0])
+			assert.Equal(t, Upstream{Port: 10}, cf.Upstreams[10])
+		}
+	})
+
+	t.Run("indexOutOfRange", func(t *testing.T) {
+		err := NewConfReader("elems").WithArgs([]string{"--upstreams.5.host", "h"}).WithFS(fsys).WithEnv(nil).Read(&ElemsConfig{})
+		assert.EqualError(t, err, "flag --upstreams.5.host: index 5 is out of range, upstreams has 1 elements")
+
+		err = NewConfReader("elems").WithArgs([]string{"--upstreams.999999999.host", "h"}).WithFS(fstest.MapFS{}).WithEnv(nil).Read(&ElemsConfig{})
+		assert.EqualError(t, err, "flag --upstreams.999999999.host: index 999999999 is out of range, upstreams has 0 elements")
+
+		// env vars can't skip elements either, UPSTREAMS_1_HOST makes the second element
+		err = NewConfReader("elems").WithArgs([]string{}).WithFS(fsys).
+			WithEnv(map[string]string{"UPSTREAMS_1_HOST": "b", "UPSTREAMS_5_HOST": "x"}).Read(&ElemsConfig{})
+		var envErr *EnvError
+		if assert.ErrorAs(t, err, &envErr) {
+			assert.Equal(t, "UPSTREAMS_5_HOST", envErr.Var)
+			assert.Equal(t, "upstreams.5.host", envErr.Field)
+			assert.EqualError(t, err, `invalid value "x" of env var UPSTREAMS_5_HOST for field upstreams.5.host: index 5 is out of range, upstreams has 2 elements`)
+		}
+	})
+
+	t.Run("unknownField", func(t *testing.T) {
+		err := NewConfReader("elems").WithFS(fsys).WithEnv(nil).WithArgs([]string{"--upstreams.0.name", "x"}).Read(&ElemsConfig{})
+		var flagErr *FlagError
+		if assert.ErrorAs(t, err, &flagErr) {
+			assert.Equal(t, FlagErrorUnknown, flagErr.Kind)
+		}
+	})
+
+	t.Run("envLookup", func(t *testing.T) {
+		// keys of maps can't be found in env vars that can't be listed
+		cf := &ElemsConfig{}
+		env := map[string]string{"TENANTS_ACME_QUOTA": "6", "TENANTS_BETA_QUOTA": "7"}
+		err := NewConfReader("elems").WithArgs([]string{}).WithFS(fsys).WithEnvLookup(func(name string) (string, bool) {
+			v, ok := env[name]
+			return v, ok
+		}).Read(cf)
+		if assert.NoError(t, err) {
+			assert.Equal(t, map[string]TenantConf{"acme": {Quota: 6}}, cf.Tenants)
+		}
+	})
+}
@@ -0,0 +1,386 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/pflag"
+)
+
+// ErrHelp is returned by Read when -h or --help flag is passed and there is no such flag in the config struct.
+// Help is printed before it is returned, see ConfReader.WithHelpOutput.
+var ErrHelp = pflag.ErrHelp
+
+// ErrNoCommand is returned by Run when the config has commands but none was given on the command line.
+var ErrNoCommand = errors.New("no command given")
+
+// FlagErrorKind is a reason why command line flags could not be parsed.
+type FlagErrorKind int
+
+const (
+	// FlagErrorUnknown means that the flag is not defined by the config struct
+	FlagErrorUnknown FlagErrorKind = iota
+	// FlagErrorInvalidValue means that the value could not be parsed into the type of the field
+	FlagErrorInvalidValue
+	// FlagErrorMissingValue means that the flag requires a value but none was given
+	FlagErrorMissingValue
+	// FlagErrorSyntax means that the argument is not a valid flag, e.g. "---foo"
+	FlagErrorSyntax
+)
+
+func (k FlagErrorKind) String() string {
+	switch k {
+	case FlagErrorUnknown:
+		return "unknown flag"
+	case FlagErrorInvalidValue:
+		return "invalid value"
+	case FlagErrorMissingValue:
+		return "missing value"
+	default:
+		return "bad syntax"
+	}
+}
+
+// FlagError is returned by Read when command line flags could not be parsed.
+// Flag is the name of the offending flag without dashes, Field is a path of the config field set by the flag if there is one.
+// Value is set only for FlagErrorInvalidValue.
+type FlagError struct {
+	Kind  FlagErrorKind
+	Flag  string
+	Field string
+	Value string
+	Err   error
+}
+
+func (e *FlagError) Error() string {
+	return "failed to parse flags: " + e.Err.Error()
+}
+
+func (e *FlagError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	unknownFlagRe      = regexp.MustCompile(`^unknown flag: --(.+)$`)
+	unknownShortFlagRe = regexp.MustCompile(`^unknown shorthand flag: '(.+?)' in -`)
+	missingValueRe     = regexp.MustCompile(`^flag needs an argument: --(.+)$`)
+	missingShortRe     = regexp.MustCompile(`^flag needs an argument: '(.+?)' in -`)
+	invalidValueRe     = regexp.MustCompile(`^invalid argument (".*") for "(?:-.+, )?--(.+?)" flag: `)
+	badSyntaxRe        = regexp.MustCompile(`^bad flag syntax: (.*)$`)
+)
+
+// newFlagError converts an error returned by pflag into FlagError.
+// pflag does not have typed errors so we have to match messages.
+func newFlagError(err error) *FlagError {
+	msg := err.Error()
+	fe := &FlagError{Kind: FlagErrorSyntax, Err: err}
+
+	if m := invalidValueRe.FindStringSubmatch(msg); m != nil {
+		fe.Kind = FlagErrorInvalidValue
+		fe.Flag = m[2]
+		fe.Value, _ = strconv.Unquote(m[1])
+	} else if m := unknownFlagRe.FindStringSubmatch(msg); m != nil {
+		fe.Kind = FlagErrorUnknown
+		fe.Flag = m[1]
+	} else if m := unknownShortFlagRe.FindStringSubmatch(msg); m != nil {
+		fe.Kind = FlagErrorUnknown
+		fe.Flag = m[1]
+	} else if m := missingValueRe.FindStringSubmatch(msg); m != nil {
+		fe.Kind = FlagErrorMissingValue
+		fe.Flag = m[1]
+	} else if m := missingShortRe.FindStringSubmatch(msg); m != nil {
+		fe.Kind = FlagErrorMissingValue
+		fe.Flag = m[1]
+	} else if m := badSyntaxRe.FindStringSubmatch(msg); m != nil {
+		fe.Flag = m[1]
+	}
+	return fe
+}
+
+// EnvError is returned by Read when value of an environment variable can not be parsed into the type of the field.
+// Field is a path of the field like "db.port".
+type EnvError struct {
+	Var   string
+	Field string
+	Value string
+	Err   error
+}
+
+func (e *EnvError) Error() string {
+	return fmt.Sprintf("invalid value %q of env var %s for field %s: %s", e.Value, e.Var, e.Field, e.Err)
+}
+
+func (e *EnvError) Unwrap() error {
+	return e.Err
+}
+
+// ArgError is returned by Read when a positional argument can not be parsed into the type of the field
+// or when there are more arguments than fields with `arg` tags. Position starts from 0, Field is empty for unexpected arguments.
+type ArgError struct {
+	Position int
+	Field    string
+	Value    string
+	Err      error
+}
+
+func (e *ArgError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("unexpected argument %q", e.Value)
+	}
+	return fmt.Sprintf("invalid value %q of argument %d for field %s: %s", e.Value, e.Position, e.Field, e.Err)
+}
+
+func (e *ArgError) Unwrap() error {
+	return e.Err
+}
+
+// ParseError is returned by Read when the config file could not be parsed. Line and Column start from 1,
+// they are 0 if the parser doesn't report them. Column is reported for JSON and TOML files.
+// Snippet is the line of the file with the error.
+type ParseError struct {
+	File    string
+	Line    int
+	Column  int
+	Snippet string
+	Err     error
+}
+
+func (e *ParseError) Error() string {
+	loc := e.File
+	if e.Line > 0 {
+		loc += ":" + strconv.Itoa(e.Line)
+		if e.Column > 0 {
+			loc += ":" + strconv.Itoa(e.Column)
+		}
+	}
+	return loc + ": " + e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	yamlErrorRe = regexp.MustCompile(`(?s)^yaml: (?:unmarshal errors:\s*)?line (\d+): (.*)$`)
+	tomlErrorRe = regexp.MustCompile(`^\((\d+), (\d+)\): (.*)$`)
+)
+
+// newParseError converts an error returned by viper for the config file into ParseError.
+// viper errors are not typed, so positions are matched in YAML and TOML messages, JSON is parsed again to get the offset.
+func newParseError(file string, data []byte, err error) *ParseError {
+	msg := strings.TrimPrefix(err.Error(), "While parsing config: ")
+	pe := &ParseError{File: file, Err: errors.New(msg)}
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		if m := yamlErrorRe.FindStringSubmatch(msg); m != nil {
+			pe.Line, _ = strconv.Atoi(m[1])
+			pe.Err = errors.New(m[2])
+		}
+
+	case ".toml":
+		if m := tomlErrorRe.FindStringSubmatch(msg); m != nil {
+			pe.Line, _ = strconv.Atoi(m[1])
+			pe.Column, _ = strconv.Atoi(m[2])
+			pe.Err = errors.New(m[3])
+		}
+
+	case ".json":
+		var v map[string]interface{}
+		var syntaxErr *json.SyntaxError
+		if errors.As(json.Unmarshal(data, &v), &syntaxErr) {
+			before := data[:syntaxErr.Offset]
+			pe.Line = bytes.Count(before, []byte("\n")) + 1
+			pe.Column = len(before) - bytes.LastIndexByte(before, '\n') - 1
+			pe.Err = syntaxErr
+		}
+	}
+
+	if pe.Line > 0 {
+		lines := strings.Split(string(data), "\n")
+		if pe.Line <= len(lines) {
+			pe.Snippet = strings.TrimRight(lines[pe.Line-1], "\r")
+		}
+	}
+	return pe
+}
+
+// DecodeError is returned by Read when a value could not be converted into the type of the field,
+// e.g. a string that is not a number is set for an int field. Field is a path like "db.port" or "upstreams.0.port"
+// and Source is the source that provided the value. Only the first field that could not be decoded is reported.
+type DecodeError struct {
+	Field  string
+	Source Source
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("invalid value of field %s from %s: %s", e.Field, e.Source, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	decodePathRe  = regexp.MustCompile(`'([^']*)'`)
+	decodeIndexRe = regexp.MustCompile(`\[([^\]]*)\]`)
+)
+
+// newDecodeError converts an error returned by mapstructure into DecodeError. mapstructure errors are lists of messages
+// with field paths like 'Upstreams[0].Port', the path is converted into a field key and the source is found in trace.
+// prefix is the key of the struct which was decoded, it is empty for the config struct.
+func newDecodeError(err error, prefix string, trace sourceTrace) error {
+	var msErr *mapstructure.Error
+	if !errors.As(err, &msErr) || len(msErr.Errors) == 0 {
+		return err
+	}
+	msg := msErr.Errors[0]
+	m := decodePathRe.FindStringSubmatch(msg)
+	if m == nil {
+		return err
+	}
+
+	key := strings.ToLower(decodeIndexRe.ReplaceAllString(m[1], ".$1"))
+	if prefix != "" {
+		key = prefix + "." + key
+	}
+	de := &DecodeError{Field: key, Err: errors.New(strings.Replace(msg, m[0], "'"+key+"'", 1))}
+	// values of elements and map keys could be set by the source of the whole field
+	for k := key; ; k = k[:strings.LastIndex(k, ".")] {
+		if sources := trace[k]; len(sources) > 0 {
+			de.Source = sources[len(sources)-1]
+			break
+		}
+		if !strings.Contains(k, ".") {
+			break
+		}
+	}
+	return de
+}
+
+// UnknownKey is a key of the config file or an env var that doesn't match any field of the config struct.
+// Key is a path like "db.hots" for keys of the config file and a name for env vars.
+// File is the path of the config file, it is empty for env vars.
+// Suggestion is the nearest field path or env var name, it is empty if no field is similar.
+type UnknownKey struct {
+	Key        string
+	File       string
+	Suggestion string
+}
+
+func (k UnknownKey) String() string {
+	msg := "unknown env var " + k.Key
+	if k.File != "" {
+		msg = fmt.Sprintf("unknown key %s in %s", k.Key, k.File)
+	}
+	if k.Suggestion != "" {
+		msg += ", did you mean " + k.Suggestion + "?"
+	}
+	return msg
+}
+
+// UnknownKeysError is returned by Read in strict mode when the config file or env vars have keys that don't match any field.
+type UnknownKeysError struct {
+	Keys []UnknownKey
+}
+
+func (e *UnknownKeysError) Error() string {
+	msgs := make([]string, 0, len(e.Keys))
+	for _, k := range e.Keys {
+		msgs = append(msgs, k.String())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// FieldError describes a field that failed validation. Field is a config key like "db.host",
+// Env and Flag are the env var and the flag that set the field, Flag is empty if the field has no flag.
+// Rule is the failed rule of the `validate` tag, like "max", and Param is its parameter, like "10".
+// Value is the invalid value, it is not included in the message since it could be a secret.
+// Err is set instead of them if the Validate method of a struct failed, Field is the key of the struct then,
+// it is empty for the config struct itself.
+type FieldError struct {
+	Field string
+	Env   string
+	Flag  string
+	Rule  string
+	Param string
+	Value interface{}
+	Err   error
+}
+
+func (f FieldError) String() string {
+	if f.Err != nil {
+		if f.Field == "" {
+			return f.Err.Error()
+		}
+		return f.Field + ": " + f.Err.Error()
+	}
+
+	rule := f.Rule
+	if f.Param != "" {
+		rule += "=" + f.Param
+	}
+	// Value is not printed, it could be a secret
+	msg := fmt.Sprintf("%s: failed on %q", f.Field, rule)
+	sources := "env " + f.Env
+	if f.Flag != "" {
+		sources += ", flag --" + f.Flag
+	}
+	return msg + " (" + sources + ")"
+}
+
+// ValidationError is returned by Read when the config doesn't pass validation by `validate` tags
+// or by Validate methods, which are called only if tags are valid.
+// Fields lists every failed field, Err is the error returned by the validator or by the first failed Validate method.
+// Error lists fields by their config keys, Err is still available via errors.As, e.g. as validator.ValidationErrors.
+type ValidationError struct {
+	Fields []FieldError
+	Err    error
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.String())
+	}
+	return "validation error: " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Details returns a multi-line description of the failed fields, suitable for printing at startup:
+//
+//	invalid config:
+//	  db.host: failed on "required" (env DB_HOST, flag --db.host)
+//	  db.port: failed on "max=65535" (env DB_PORT, flag --db.port)
+func (e *ValidationError) Details() string {
+	var sb strings.Builder
+	sb.WriteString("invalid config:")
+	for _, f := range e.Fields {
+		sb.WriteString("\n  " + f.String())
+	}
+	return sb.String()
+}
+
+// ReloadError is reported when reloaded config was rejected. The config keeps the last valid values.
+type ReloadError struct {
+	Err error
+}
+
+func (e *ReloadError) Error() string {
+	return "config reload rejected: " + e.Err.Error()
+}
+
+func (e *ReloadError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type FlagErrorConfig struct {
+	Port    int    `flag:"port"`
+	Host    string `flag:"host"`
+	Verbose bool
+}
+
+func Test_FlagErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		kind  FlagErrorKind
+		flag  string
+		value string
+	}{
+		{name: "unknownFlag", args: []string{"--nope"}, kind: FlagErrorUnknown, flag: "nope"},
+		{name: "unknownShorthand", args: []string{"-x"}, kind: FlagErrorUnknown, flag: "x"},
+		{name: "invalidValue", args: []string{"--port", "abc"}, kind: FlagErrorInvalidValue, flag: "port", value: "abc"},
+		{name: "missingValue", args: []string{"--host"}, kind: FlagErrorMissingValue, flag: "host"},
+		{name: "badSyntax", args: []string{"---host"}, kind: FlagErrorSyntax, flag: "---host"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			cfg := &FlagErrorConfig{}
+			err := NewConfReader("flag-errors").WithArgs(tt.args).WithEnv(nil).Read(cfg)
+
+			var flagErr *FlagError
+			if assert.True(t, errors.As(err, &flagErr), "expected FlagError, got %v", err) {
+				assert.Equal(t, tt.kind, flagErr.Kind)
+				assert.Equal(t, tt.flag, flagErr.Flag)
+				assert.Equal(t, tt.value, flagErr.Value)
+				assert.Contains(t, err.Error(), "failed to parse flags")
+			}
+		})
+	}
+
+	t.Run("help", func(t *testing.T) {
+		t.Parallel()
+		cfg := &FlagErrorConfig{}
+		err := NewConfReader("flag-errors").WithArgs([]string{"--help"}).WithEnv(nil).WithHelpOutput(io.Discard).Read(cfg)
+		assert.ErrorIs(t, err, ErrHelp)
+	})
+}
+
+func Test_ParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		data    string
+		line    int
+		column  int
+		snippet string
+	}{
+		{name: "yaml", file: "parse.yaml", data: "host: localhost\nport: [\n", line: 2, snippet: "port: ["},
+		{name: "json", file: "parse.json", data: "{\n  \"host\": \"localhost\",\n  \"port\" 80\n}", line: 3, column: 10, snippet: `  "port" 80`},
+		{name: "toml", file: "parse.toml", data: "host = \"localhost\"\nport = = 80\n", line: 2, column: 8, snippet: "port = = 80"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fsys := fstest.MapFS{tt.file: {Data: []byte(tt.data)}}
+			err := NewConfReader("parse").WithFS(fsys).WithArgs(nil).WithEnv(nil).Read(&FlagErrorConfig{})
+
+			var parseErr *ParseError
+			if assert.ErrorAs(t, err, &parseErr) {
+				assert.Equal(t, tt.file, parseErr.File)
+				assert.Equal(t, tt.line, parseErr.Line)
+				assert.Equal(t, tt.column, parseErr.Column)
+				assert.Equal(t, tt.snippet, parseErr.Snippet)
+				assert.Contains(t, err.Error(), "failed to unmarshal struct")
+			}
+		})
+	}
+}
+
+func Test_DecodeErrors(t *testing.T) {
+	t.Run("env", func(t *testing.T) {
+		err := NewConfReader("decode").WithFS(fstest.MapFS{}).WithArgs(nil).WithEnv(map[string]string{"PORT": "abc"}).Read(&FlagErrorConfig{})
+
+		var decodeErr *DecodeError
+		if assert.ErrorAs(t, err, &decodeErr) {
+			assert.Equal(t, "port", decodeErr.Field)
+			assert.Equal(t, Source{Kind: SourceEnv, Name: "PORT", Value: "abc"}, decodeErr.Source)
+			assert.Contains(t, err.Error(), `invalid value of field port from env PORT "abc": cannot parse 'port' as int`)
+		}
+	})
+
+	t.Run("element", func(t *testing.T) {
+		fsys := fstest.MapFS{"decode.yaml": {Data: []byte("upstreams:\n  - host: a\n    port: eighty\n")}}
+		err := NewConfReader("decode").WithFS(fsys).WithArgs(nil).WithEnv(nil).Read(&ElemsConfig{})
+
+		var decodeErr *DecodeError
+		if assert.ErrorAs(t, err, &decodeErr) {
+			assert.Equal(t, "upstreams.0.port", decodeErr.Field)
+			assert.Equal(t, Source{Kind: SourceFile, Name: "decode.yaml", Value: "eighty"}, decodeErr.Source)
+		}
+	})
+}
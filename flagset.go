@@ -0,0 +1,85 @@
+package config
+
+import (
+	"reflect"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+// CobraCommand is implemented by *cobra.Command. It is used to bind config to a cobra command without depending on cobra.
+type CobraCommand interface {
+	Flags() *pflag.FlagSet
+}
+
+// WithFlagSet makes the reader add flags of the config struct to an existing flag set instead of creating its own.
+// Call BindFlags to add flags before the flag set is parsed by the host application, Read then takes values of
+// the parsed flags. If the flag set is not parsed by the time of Read, Read adds flags and parses it.
+// Commands are not supported with an external flag set.
+func (c *ConfReader) WithFlagSet(flags *pflag.FlagSet) *ConfReader {
+	c.flagSet = flags
+	c.flagsBound = false
+	return c
+}
+
+// BindFlags adds flags of the config struct to the flag set set by WithFlagSet.
+func (c *ConfReader) BindFlags(configStruct interface{}) error {
+	if c.flagSet == nil {
+		return errors.New("flag set is not set, use WithFlagSet")
+	}
+	if configStruct == nil {
+		return errors.New("config struct is nil")
+	}
+
+	fields, commands, err := c.fields(reflect.TypeOf(configStruct))
+	if err != nil {
+		return err
+	}
+	if len(commands) > 0 {
+		return errors.New("commands can't be used with an external flag set")
+	}
+	return c.bindFlags(fields)
+}
+
+// BindCobraCommand adds flags of the config struct to the flags of the cobra command, so they are parsed by cobra.
+// Call Read in the Run function of the command to read the config:
+//
+//	reader := config.NewConfReader("myapp")
+//	cmd := &cobra.Command{
+//		Use: "serve",
+//		RunE: func(cmd *cobra.Command, args []string) error {
+//			return reader.Read(&conf)
+//		},
+//	}
+//	err := reader.BindCobraCommand(cmd, &conf)
+func (c *ConfReader) BindCobraCommand(cmd CobraCommand, configStruct interface{}) error {
+	return c.WithFlagSet(cmd.Flags()).BindFlags(configStruct)
+}
+
+// bindFlags adds flags of the fields to the external flag set once
+func (c *ConfReader) bindFlags(fields map[string]*flagInfo) error {
+	if c.flagsBound {
+		return nil
+	}
+	if err := c.addFlags(c.flagSet, fields); err != nil {
+		return err
+	}
+	c.flagsBound = true
+	return nil
+}
+
+// parseExternalFlags adds flags to the external flag set and parses it if the host application has not done it yet
+func (c *ConfReader) parseExternalFlags(fields map[string]*flagInfo, commands []*command, args []string) (*command, []*pflag.FlagSet, []string, error) {
+	if len(commands) > 0 {
+		return nil, nil, nil, errors.New("commands can't be used with an external flag set")
+	}
+	if err := c.bindFlags(fields); err != nil {
+		return nil, nil, nil, err
+	}
+	if !c.flagSet.Parsed() {
+		if err := c.parseFlags(c.flagSet, args, fields); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return nil, []*pflag.FlagSet{c.flagSet}, c.flagSet.Args(), nil
+}
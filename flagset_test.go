@@ -0,0 +1,98 @@
+package config
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+type FlagSetConfig struct {
+	Port int `default:"8080" short:"p"`
+	Db   struct {
+		Host string `default:"localhost"`
+	}
+	Files []string `arg:"rest"`
+}
+
+// fakeCommand stands for *cobra.Command
+type fakeCommand struct {
+	flags *pflag.FlagSet
+}
+
+func (c *fakeCommand) Flags() *pflag.FlagSet {
+	return c.flags
+}
+
+func Test_FlagSet(t *testing.T) {
+	t.Run("parsedByHost", func(t *testing.T) {
+		flags := pflag.NewFlagSet("host", pflag.ContinueOnError)
+		logLevel := flags.String("log-level", "info", "log level")
+
+		cf := &FlagSetConfig{}
+		reader := NewConfReader("flagset").WithFlagSet(flags).WithEnv(map[string]string{"DB_HOST": "db"}).WithFS(fstest.MapFS{})
+		if !assert.NoError(t, reader.BindFlags(cf)) {
+			return
+		}
+		assert.Equal(t, "8080", flags.Lookup("port").DefValue)
+
+		assert.NoError(t, flags.Parse([]string{"--log-level", "debug", "-p", "9090", "a.txt"}))
+		if assert.NoError(t, reader.Read(cf)) {
+			assert.Equal(t, "debug", *logLevel)
+			assert.Equal(t, 9090, cf.Port)
+			assert.Equal(t, "db", cf.Db.Host)
+			assert.Equal(t, []string{"a.txt"}, cf.Files)
+		}
+
+		// flags are bound once, so reading again doesn't redefine them
+		assert.NoError(t, reader.Read(&FlagSetConfig{}))
+	})
+
+	t.Run("parsedByRead", func(t *testing.T) {
+		flags := pflag.NewFlagSet("host", pflag.ContinueOnError)
+		cf := &FlagSetConfig{}
+		err := NewConfReader("flagset").WithFlagSet(flags).WithArgs([]string{"--db.host", "h"}).WithEnv(nil).WithFS(fstest.MapFS{}).Read(cf)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "h", cf.Db.Host)
+			assert.True(t, flags.Parsed())
+		}
+	})
+
+	t.Run("cobra", func(t *testing.T) {
+		cmd := &fakeCommand{flags: pflag.NewFlagSet("serve", pflag.ContinueOnError)}
+		cf := &FlagSetConfig{}
+		reader := NewConfReader("flagset").WithEnv(nil).WithFS(fstest.MapFS{})
+		if assert.NoError(t, reader.BindCobraCommand(cmd, cf)) {
+			assert.NoError(t, cmd.Flags().Parse([]string{"--port", "1"}))
+			if assert.NoError(t, reader.Read(cf)) {
+				assert.Equal(t, 1, cf.Port)
+			}
+		}
+	})
+
+	t.Run("alreadyDefined", func(t *testing.T) {
+		flags := pflag.NewFlagSet("host", pflag.ContinueOnError)
+		flags.Int("port", 0, "")
+		err := NewConfReader("flagset").WithFlagSet(flags).BindFlags(&FlagSetConfig{})
+		if assert.Error(t, err) {
+			assert.Equal(t, "flag --port of field port is already defined", err.Error())
+		}
+
+		flags = pflag.NewFlagSet("host", pflag.ContinueOnError)
+		flags.BoolP("pretty", "p", false, "")
+		err = NewConfReader("flagset").WithFlagSet(flags).BindFlags(&FlagSetConfig{})
+		if assert.Error(t, err) {
+			assert.Equal(t, "shorthand -p of flag --port is already defined", err.Error())
+		}
+	})
+
+	t.Run("noFlagSet", func(t *testing.T) {
+		assert.Error(t, NewConfReader("flagset").BindFlags(&FlagSetConfig{}))
+	})
+
+	t.Run("commands", func(t *testing.T) {
+		flags := pflag.NewFlagSet("host", pflag.ContinueOnError)
+		assert.Error(t, NewConfReader("flagset").WithFlagSet(flags).BindFlags(&CliConfig{}))
+	})
+}
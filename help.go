@@ -0,0 +1,151 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// WithHelpOutput sets where help requested by --help or -h is printed. It is printed to os.Stderr by default.
+func (c *ConfReader) WithHelpOutput(w io.Writer) *ConfReader {
+	c.helpOutput = w
+	return c
+}
+
+func (c *ConfReader) helpWriter() io.Writer {
+	if c.helpOutput != nil {
+		return c.helpOutput
+	}
+	return os.Stderr
+}
+
+// writeHelp prints usage line, commands, positional arguments and flags grouped by nested structs. Every flag is printed with its type, usage,
+// default value, env var that sets the same field and whether the field is required.
+func (c *ConfReader) writeHelp(w io.Writer, name string, tagsInfo map[string]*flagInfo, flags *pflag.FlagSet, commands []*command) {
+	groups := map[string][]string{}
+	for k, info := range tagsInfo {
+		if info.Arg != "" || flags.Lookup(info.Name) == nil {
+			continue
+		}
+		group := ""
+		if i := strings.LastIndex(k, "."); i >= 0 {
+			group = k[:i]
+		}
+		groups[group] = append(groups[group], k)
+	}
+	groupNames := make([]string, 0, len(groups))
+	for g, keys := range groups {
+		sort.Strings(keys)
+		groupNames = append(groupNames, g)
+	}
+	// top level fields go first since "" is sorted before other names
+	sort.Strings(groupNames)
+
+	// columns are aligned across all groups
+	type row struct{ flag, typ, help string }
+	flagWidth, typeWidth := len("-h, --help"), 0
+	newRow := func(r row) row {
+		if len(r.flag) > flagWidth {
+			flagWidth = len(r.flag)
+		}
+		if len(r.typ) > typeWidth {
+			typeWidth = len(r.typ)
+		}
+		return r
+	}
+
+	usage := "Usage: " + name + " [flags]"
+	if len(commands) > 0 {
+		usage += " <command>"
+	}
+	var cmdRows []row
+	for _, cmd := range commands {
+		cmdRows = append(cmdRows, newRow(row{flag: cmd.name, help: cmd.usage}))
+	}
+	var argRows []row
+	argKeys, rest, _ := c.positionalFields(tagsInfo)
+	if rest != "" {
+		argKeys = append(argKeys, rest)
+	}
+	for _, k := range argKeys {
+		info := tagsInfo[k]
+		argName := k[strings.LastIndex(k, ".")+1:]
+		if k == rest {
+			argName += "..."
+		}
+		if info.Required {
+			usage += " <" + argName + ">"
+		} else {
+			usage += " [" + argName + "]"
+		}
+		argRows = append(argRows, newRow(row{flag: argName, typ: info.Type.String(), help: c.flagHelp(k, info)}))
+	}
+
+	rows := map[string][]row{}
+	for _, g := range groupNames {
+		for _, k := range groups[g] {
+			info := tagsInfo[k]
+			r := row{flag: "    --" + info.Name, typ: flags.Lookup(info.Name).Value.Type(), help: c.flagHelp(k, info)}
+			if info.Short != "" {
+				r.flag = "-" + info.Short + ", --" + info.Name
+			}
+			if valueType(info.Type).Kind() == reflect.Bool {
+				r.typ = ""
+			}
+			rows[g] = append(rows[g], newRow(r))
+		}
+	}
+
+	fmt.Fprintf(w, "%s\n\n", usage)
+	if len(cmdRows) > 0 {
+		fmt.Fprintln(w, "Commands:")
+		for _, r := range cmdRows {
+			fmt.Fprintln(w, strings.TrimRight(fmt.Sprintf("  %-*s   %s", flagWidth+typeWidth+3, r.flag, r.help), " "))
+		}
+		fmt.Fprintln(w)
+	}
+	if len(argRows) > 0 {
+		fmt.Fprintln(w, "Arguments:")
+		for _, r := range argRows {
+			fmt.Fprintf(w, "  %-*s   %-*s   %s\n", flagWidth, r.flag, typeWidth, r.typ, r.help)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w, "Flags:")
+	fmt.Fprintf(w, "  %-*s   %-*s   %s\n", flagWidth, "-h, --help", typeWidth, "", "show help")
+	for _, g := range groupNames {
+		if g != "" {
+			fmt.Fprintf(w, "\n%s.*:\n", g)
+		}
+		for _, r := range rows[g] {
+			fmt.Fprintf(w, "  %-*s   %-*s   %s\n", flagWidth, r.flag, typeWidth, r.typ, r.help)
+		}
+	}
+}
+
+// flagHelp returns usage text of the field followed by its default value, env var and required mark
+func (c *ConfReader) flagHelp(key string, info *flagInfo) string {
+	var notes []string
+	if info.DefaultVal != "" {
+		if valueType(info.Type).Kind() == reflect.String {
+			notes = append(notes, fmt.Sprintf("default %q", info.FlagDefault))
+		} else {
+			notes = append(notes, "default "+info.FlagDefault)
+		}
+	}
+	notes = append(notes, "env "+c.envVarNames(key, info)[0])
+	if info.Required {
+		notes = append(notes, "required")
+	}
+
+	help := "(" + strings.Join(notes, ", ") + ")"
+	if info.Usage != "" {
+		help = info.Usage + " " + help
+	}
+	return help
+}
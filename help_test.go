@@ -0,0 +1,49 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type HelpConfig struct {
+	Verbose bool `usage:"verbose output"`
+	Db      struct {
+		Host     string `default:"localhost" validate:"required" usage:"database host"`
+		Password string `envvar:"DB_PASS"`
+	}
+	Server struct {
+		Port    int           `default:"8080"`
+		Timeout time.Duration `default:"30s" flag:"timeout"`
+	}
+}
+
+func Test_Help(t *testing.T) {
+	for _, arg := range []string{"--help", "-h"} {
+		t.Run(arg, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			reader := NewConfReader("helpapp").WithPrefix("app").
+				WithArgs([]string{arg}).WithEnv(nil).WithFS(fstest.MapFS{}).WithHelpOutput(out)
+
+			err := reader.Read(&HelpConfig{})
+			assert.Equal(t, ErrHelp, err)
+			assert.Equal(t, `Usage: helpapp [flags]
+
+Flags:
+  -h, --help                     show help
+      --verbose                  verbose output (env APP_VERBOSE)
+
+db.*:
+      --db.host       string     database host (default "localhost", env APP_DB_HOST, required)
+      --db.password   string     (env DB_PASS)
+
+server.*:
+      --server.port   int        (default 8080, env APP_SERVER_PORT)
+      --timeout       duration   (default 30s, env APP_SERVER_TIMEOUT)
+`, out.String())
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+)
+
+// Holder keeps the latest snapshot of the config. Snapshots are replaced as a whole on reload,
+// so readers never observe a partially applied reload and don't need any locking.
+type Holder[T any] struct {
+	p atomic.Pointer[T]
+}
+
+// Load returns the latest config snapshot, or nil if the holder is empty. The snapshot is shared between readers
+// and must not be modified.
+func (h *Holder[T]) Load() *T {
+	return h.p.Load()
+}
+
+func (h *Holder[T]) store(conf *T) {
+	h.p.Store(conf)
+}
+
+// WatchHolder reads config into a new instance of T, starts watching for config changes until ctx is done
+// and returns a holder that always contains the latest valid config. It is an alternative to the mutex returned by Watch.
+// Like WatchContext, it returns an error if watching can't be started, e.g. if there is no config file.
+// Rejected reloads are reported to OnReloadError handlers, or logged if there are none.
+func WatchHolder[T any](ctx context.Context, c *ConfReader) (*Holder[T], error) {
+	conf := new(T)
+	if err := c.Read(conf); err != nil {
+		return nil, err
+	}
+
+	err := c.watch(ctx, func(err error) {
+		if !c.callErrorHandlers(err) {
+			log.Printf("failed to reload config: %s\n", err)
+		}
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the handler is registered only once watching started, so a failed call leaves nothing on the reader
+	h := &Holder[T]{}
+	OnChange(c, func(_, new *T, _ []FieldChange) {
+		h.store(new)
+	})
+	// conf itself is updated in place on reload, so the holder gets its own copy. It is taken after the handler
+	// is registered and under the lock, so a reload that happened in between is not lost.
+	c.mu.RLock()
+	snapshot := *conf
+	h.store(&snapshot)
+	c.mu.RUnlock()
+	return h, nil
+}
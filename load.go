@@ -0,0 +1,127 @@
+package config
+
+import (
+	"io"
+	"io/fs"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/spf13/pflag"
+)
+
+// Option configures ConfReader created by Load and MustLoad.
+type Option func(c *ConfReader)
+
+// WithSearchDirs sets directories to search for the config file. See ConfReader.WithSearchDirs.
+func WithSearchDirs(dirs ...string) Option {
+	return func(c *ConfReader) {
+		c.WithSearchDirs(dirs...)
+	}
+}
+
+// WithPrefix sets the prefix for environment variables. See ConfReader.WithPrefix.
+func WithPrefix(prefix string) Option {
+	return func(c *ConfReader) {
+		c.WithPrefix(prefix)
+	}
+}
+
+// WithArgs sets command line arguments to parse flags from. See ConfReader.WithArgs.
+func WithArgs(args []string) Option {
+	return func(c *ConfReader) {
+		c.WithArgs(args)
+	}
+}
+
+// WithEnv sets environment variables to read config from. See ConfReader.WithEnv.
+func WithEnv(env map[string]string) Option {
+	return func(c *ConfReader) {
+		c.WithEnv(env)
+	}
+}
+
+// WithEnvLookup sets a function to look up environment variables. See ConfReader.WithEnvLookup.
+func WithEnvLookup(lookup func(string) (string, bool)) Option {
+	return func(c *ConfReader) {
+		c.WithEnvLookup(lookup)
+	}
+}
+
+// WithFS sets a filesystem to search the config file in. See ConfReader.WithFS.
+func WithFS(fsys fs.FS) Option {
+	return func(c *ConfReader) {
+		c.WithFS(fsys)
+	}
+}
+
+// WithExitOnFlagError makes Load exit the process if flags could not be parsed. See ConfReader.WithExitOnFlagError.
+func WithExitOnFlagError() Option {
+	return func(c *ConfReader) {
+		c.WithExitOnFlagError()
+	}
+}
+
+// WithStrict makes Load fail on unknown keys of the config file and env vars. See ConfReader.WithStrict.
+func WithStrict() Option {
+	return func(c *ConfReader) {
+		c.WithStrict()
+	}
+}
+
+// WithValidator sets the validator that checks `validate` tags. See ConfReader.WithValidator.
+func WithValidator(v *validator.Validate) Option {
+	return func(c *ConfReader) {
+		c.WithValidator(v)
+	}
+}
+
+// WithType registers a parser for fields of type T. See RegisterType.
+func WithType[T any](parse func(string) (T, error)) Option {
+	return func(c *ConfReader) {
+		RegisterType(c, parse)
+	}
+}
+
+// WithHelpOutput sets where Load prints help requested by --help. See ConfReader.WithHelpOutput.
+func WithHelpOutput(w io.Writer) Option {
+	return func(c *ConfReader) {
+		c.WithHelpOutput(w)
+	}
+}
+
+// WithCommand registers a subcommand. See ConfReader.AddCommand.
+func WithCommand(name string, configStruct interface{}, handler func() error) Option {
+	return func(c *ConfReader) {
+		c.AddCommand(name, configStruct, handler)
+	}
+}
+
+// WithFlagSet makes Load add flags to an existing flag set. See ConfReader.WithFlagSet.
+func WithFlagSet(flags *pflag.FlagSet) Option {
+	return func(c *ConfReader) {
+		c.WithFlagSet(flags)
+	}
+}
+
+// Load reads configuration into a new instance of T. It is a typed shortcut for NewConfReader(configName).Read(&conf).
+// configName is a name of config file name without extension and env vars prefix
+func Load[T any](configName string, opts ...Option) (*T, error) {
+	c := NewConfReader(configName)
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	conf := new(T)
+	if err := c.Read(conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// MustLoad is like Load but panics if configuration could not be read.
+func MustLoad[T any](configName string, opts ...Option) *T {
+	conf, err := Load[T](configName, opts...)
+	if err != nil {
+		panic("config: " + err.Error())
+	}
+	return conf
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// SourceKind is a kind of source a configuration value can come from.
+type SourceKind int
+
+const (
+	// SourceNone means that no source set the value, so the field keeps its zero value
+	SourceNone SourceKind = iota
+	// SourceDefault is a value from the `default` tag
+	SourceDefault
+	// SourceFile is a value from the config file
+	SourceFile
+	// SourceEnv is a value from an environment variable
+	SourceEnv
+	// SourceFlag is a value from a command line flag
+	SourceFlag
+	// SourceArg is a value from a positional command line argument
+	SourceArg
+)
+
+func (k SourceKind) String() string {
+	switch k {
+	case SourceDefault:
+		return "default"
+	case SourceFile:
+		return "file"
+	case SourceEnv:
+		return "env"
+	case SourceFlag:
+		return "flag"
+	case SourceArg:
+		return "arg"
+	default:
+		return "none"
+	}
+}
+
+// Source describes a value provided by one of the configuration sources.
+// Name is a config file path, an environment variable name, a flag name or a position of an argument.
+// It is empty for default values.
+type Source struct {
+	Kind  SourceKind
+	Name  string
+	Value interface{}
+}
+
+func (s Source) String() string {
+	switch s.Kind {
+	case SourceNone:
+		return "not set"
+	case SourceDefault:
+		return fmt.Sprintf("default %q", fmt.Sprint(s.Value))
+	default:
+		return fmt.Sprintf("%s %s %q", s.Kind, s.Name, fmt.Sprint(s.Value))
+	}
+}
+
+// FieldProvenance tells which source set the value of a config field.
+// Overridden contains values of sources with lower precedence that were overridden, starting from the closest one.
+type FieldProvenance struct {
+	Field      string
+	Source     Source
+	Overridden []Source
+}
+
+func (p FieldProvenance) String() string {
+	s := p.Field + ": " + p.Source.String()
+	for _, o := range p.Overridden {
+		s += ", overrides " + o.String()
+	}
+	return s
+}
+
+// Explain lists every config field along with the source that set its value during the last successful Read.
+// Fields are sorted by path.
+func (c *ConfReader) Explain() []FieldProvenance {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	res := make([]FieldProvenance, 0, len(c.provenance))
+	for _, p := range c.provenance {
+		res = append(res, p)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Field < res[j].Field
+	})
+	return res
+}
+
+// Provenance returns the provenance of a field by its path, like "db.host". The second value is false if there is no such field.
+func (c *ConfReader) Provenance(field string) (FieldProvenance, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	p, ok := c.provenance[strings.ToLower(field)]
+	return p, ok
+}
+
+// sourceTrace collects values provided for each field in order of precedence
+type sourceTrace map[string][]Source
+
+func newSourceTrace(tagsInfo map[string]*flagInfo, file *viper.Viper, fileName string) sourceTrace {
+	trace := sourceTrace{}
+	for k, info := range tagsInfo {
+		trace[k] = nil
+		if info.DefaultVal != "" {
+			trace.add(k, Source{Kind: SourceDefault, Value: info.DefaultVal})
+		}
+		if info.Elem != nil {
+			if v, ok := info.Elem.get(file.Get(info.Elem.collection)); ok {
+				trace.add(k, Source{Kind: SourceFile, Name: fileName, Value: v})
+			}
+		} else if file.InConfig(k) {
+			trace.add(k, Source{Kind: SourceFile, Name: fileName, Value: file.Get(k)})
+		}
+	}
+	return trace
+}
+
+func (t sourceTrace) add(key string, s Source) {
+	t[key] = append(t[key], s)
+}
+
+func (t sourceTrace) provenance() map[string]FieldProvenance {
+	res := make(map[string]FieldProvenance, len(t))
+	for k, sources := range t {
+		p := FieldProvenance{Field: k}
+		if len(sources) > 0 {
+			p.Source = sources[len(sources)-1]
+			for i := len(sources) - 2; i >= 0; i-- {
+				p.Overridden = append(p.Overridden, sources[i])
+			}
+		}
+		res[k] = p
+	}
+	return res
+}
@@ -0,0 +1,213 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cast"
+)
+
+// WithStrict makes Read return *UnknownKeysError if the config file has keys that don't match any field,
+// e.g. a typo like `dbNmae:`, or if env vars with the prefix set by WithPrefix don't match any field.
+// Env vars are only checked if the prefix is set and they could be listed, i.e. WithEnvLookup is not used.
+func (c *ConfReader) WithStrict() *ConfReader {
+	c.strict = true
+	return c
+}
+
+// checkStrict returns *UnknownKeysError if the config file or prefixed env vars have keys that don't match the fields.
+// fields should include fields of all commands, so sections of commands that are not selected are not reported.
+func (c *ConfReader) checkStrict(fields map[string]*flagInfo) error {
+	all := make(map[string]*flagInfo, len(fields))
+	for k, info := range fields {
+		all[k] = info
+	}
+	// fields of elements from the file and env vars are known fields too,
+	// env vars with indexes out of range are not and are reported with the other unknown keys
+	_ = c.addElemEnv(all)
+
+	var unknown []UnknownKey
+	fileKeys := c.viper.AllKeys()
+	sort.Strings(fileKeys)
+	for _, key := range fileKeys {
+		for _, k := range c.unknownFileKeys(all, key) {
+			unknown = append(unknown, UnknownKey{Key: k.key, File: c.configFile, Suggestion: nearest(k.key, k.candidates)})
+		}
+	}
+
+	if c.envVarPrefix != "" {
+		known := map[string]bool{}
+		var names []string
+		for k, info := range all {
+			for _, name := range c.envVarNames(k, info) {
+				known[name] = true
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		environ := append([]string{}, c.environ()...)
+		sort.Strings(environ)
+		prefix := strings.ToUpper(c.envVarPrefix) + "_"
+		for _, name := range environ {
+			if strings.HasPrefix(name, prefix) && !known[name] {
+				unknown = append(unknown, UnknownKey{Key: name, Suggestion: nearest(name, names)})
+			}
+		}
+	}
+
+	if len(unknown) > 0 {
+		return &UnknownKeysError{Keys: unknown}
+	}
+	return nil
+}
+
+// unknownKey is a key that doesn't match any field and keys of fields that could be meant instead
+type unknownKey struct {
+	key        string
+	candidates []string
+}
+
+// unknownFileKeys checks a key of the config file. Keys inside maps and values of custom types are not checked,
+// except fields of elements of slices and maps of structs.
+func (c *ConfReader) unknownFileKeys(fields map[string]*flagInfo, key string) []unknownKey {
+	if info, ok := fields[key]; ok {
+		leaves := c.elemFields(info.Type)
+		if leaves == nil || info.Type.Kind() != reflect.Slice {
+			return nil
+		}
+		// slices are leaves for viper, so keys of their elements are checked here
+		var res []unknownKey
+		for i, elem := range cast.ToSlice(c.viper.Get(key)) {
+			for _, path := range flattenKeys(elem, "") {
+				if !knownLeaf(leaves, path) {
+					prefix := key + "." + strconv.Itoa(i) + "."
+					res = append(res, unknownKey{key: prefix + path, candidates: prefixKeys(prefix, leaves)})
+				}
+			}
+		}
+		return res
+	}
+
+	for k := range fields {
+		if strings.HasPrefix(k, key+".") {
+			// empty sections of nested structs
+			return nil
+		}
+	}
+
+	for parent := key; strings.Contains(parent, "."); {
+		parent = parent[:strings.LastIndex(parent, ".")]
+		info, ok := fields[parent]
+		if !ok {
+			continue
+		}
+		leaves := c.elemFields(info.Type)
+		if leaves == nil || info.Elem != nil {
+			// keys inside maps and values of custom types
+			return nil
+		}
+		// fields of map elements are known only if the element has such a field
+		rest := strings.SplitN(key[len(parent)+1:], ".", 2)
+		if len(rest) == 2 && knownLeaf(leaves, rest[1]) {
+			return nil
+		}
+		prefix := parent + "." + rest[0] + "."
+		return []unknownKey{{key: key, candidates: prefixKeys(prefix, leaves)}}
+	}
+
+	candidates := make([]string, 0, len(fields))
+	for k := range fields {
+		candidates = append(candidates, k)
+	}
+	sort.Strings(candidates)
+	return []unknownKey{{key: key, candidates: candidates}}
+}
+
+// knownLeaf tells if the path is a field of the element or is inside one, like a key of a map field
+func knownLeaf(leaves map[string]*flagInfo, path string) bool {
+	for p := path; ; p = p[:strings.LastIndex(p, ".")] {
+		if _, ok := leaves[p]; ok {
+			return true
+		}
+		if !strings.Contains(p, ".") {
+			return false
+		}
+	}
+}
+
+// flattenKeys returns paths of values in nested maps with lower case keys
+func flattenKeys(v interface{}, prefix string) []string {
+	m, err := cast.ToStringMapE(v)
+	if err != nil || len(m) == 0 {
+		if prefix == "" {
+			return nil
+		}
+		return []string{prefix}
+	}
+	var res []string
+	for k, v := range m {
+		res = append(res, flattenKeys(v, strings.TrimPrefix(prefix+"."+strings.ToLower(k), "."))...)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// prefixKeys returns sorted keys of leaves with the prefix
+func prefixKeys(prefix string, leaves map[string]*flagInfo) []string {
+	res := make([]string, 0, len(leaves))
+	for k := range leaves {
+		res = append(res, prefix+k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// nearest returns the candidate with the smallest edit distance to s, or an empty string if none is close enough
+func nearest(s string, candidates []string) string {
+	maxDist := len(s) / 3
+	if maxDist < 2 {
+		maxDist = 2
+	}
+
+	res, best := "", maxDist+1
+	for _, c := range candidates {
+		if d := editDistance(s, c); d < best {
+			res, best = c, d
+		}
+	}
+	return res
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	res := values[0]
+	for _, v := range values[1:] {
+		if v < res {
+			res = v
+		}
+	}
+	return res
+}
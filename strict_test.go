@@ -0,0 +1,49 @@
+package config
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type StrictConfig struct {
+	Db struct {
+		DbName string
+		Host   string
+	}
+	Upstreams []Upstream
+	Tenants   map[string]TenantConf
+	Labels    map[string]string
+}
+
+func Test_Strict(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		fsys := fstest.MapFS{"strict.yaml": {Data: []byte("db:\n  dbName: app\n" + elemsFile)}}
+		err := NewConfReader("strict").WithStrict().WithPrefix("APP").WithArgs([]string{}).WithFS(fsys).
+			WithEnv(map[string]string{"APP_DB_HOST": "localhost", "APP_TENANTS_BETA_QUOTA": "1", "OTHER": "x"}).
+			Read(&StrictConfig{})
+		assert.NoError(t, err)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		file := "db:\n  dbNmae: app\nupstreams:\n  - hots: a\ntenants:\n  acme:\n    qouta: 1\nfoo: 1\n"
+		fsys := fstest.MapFS{"strict.yaml": {Data: []byte(file)}}
+		err := NewConfReader("strict").WithStrict().WithPrefix("APP").WithArgs([]string{}).WithFS(fsys).
+			WithEnv(map[string]string{"APP_DB_HOTS": "localhost", "APP_UPSTREAMS_2_HOST": "b"}).
+			Read(&StrictConfig{})
+
+		var strictErr *UnknownKeysError
+		if assert.ErrorAs(t, err, &strictErr) {
+			assert.Equal(t, []UnknownKey{
+				{Key: "db.dbnmae", File: "strict.yaml", Suggestion: "db.dbname"},
+				{Key: "foo", File: "strict.yaml"},
+				{Key: "tenants.acme.qouta", File: "strict.yaml", Suggestion: "tenants.acme.quota"},
+				{Key: "upstreams.0.hots", File: "strict.yaml", Suggestion: "upstreams.0.host"},
+				{Key: "APP_DB_HOTS", Suggestion: "APP_DB_HOST"},
+				{Key: "APP_UPSTREAMS_2_HOST", Suggestion: "APP_UPSTREAMS_0_HOST"},
+			}, strictErr.Keys)
+			assert.Contains(t, err.Error(), "unknown key db.dbnmae in strict.yaml, did you mean db.dbname?")
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+)
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// defaultTypeHooks returns parsers for commonly used types that don't implement encoding.TextUnmarshaler
+func defaultTypeHooks() map[reflect.Type]func(string) (interface{}, error) {
+	return map[reflect.Type]func(string) (interface{}, error){
+		reflect.TypeOf(url.URL{}): func(s string) (interface{}, error) {
+			u, err := url.Parse(s)
+			if err != nil {
+				return nil, err
+			}
+			return *u, nil
+		},
+	}
+}
+
+// RegisterType registers a parser for fields of type t and *t. Such fields are treated as single values and
+// are parsed from strings the same way in config file, env vars and flags.
+// parse should return a value of type t.
+// Types implementing encoding.TextUnmarshaler are supported without registration.
+func (c *ConfReader) RegisterType(t reflect.Type, parse func(string) (interface{}, error)) *ConfReader {
+	if c.typeHooks == nil {
+		c.typeHooks = map[reflect.Type]func(string) (interface{}, error){}
+	}
+	c.typeHooks[t] = parse
+	return c
+}
+
+// RegisterType is a typed version of ConfReader.RegisterType.
+func RegisterType[T any](c *ConfReader, parse func(string) (T, error)) *ConfReader {
+	return c.RegisterType(reflect.TypeOf((*T)(nil)).Elem(), func(s string) (interface{}, error) {
+		return parse(s)
+	})
+}
+
+// isCustomType tells if the type or the type it points to has a registered parser or implements encoding.TextUnmarshaler
+func (c *ConfReader) isCustomType(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if _, ok := c.typeHooks[t]; ok {
+		return true
+	}
+	return reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+// parseCustom parses s into a value of a custom type t
+func (c *ConfReader) parseCustom(s string, t reflect.Type) (interface{}, error) {
+	base := t
+	if t.Kind() == reflect.Ptr {
+		base = t.Elem()
+	}
+
+	v := reflect.New(base)
+	if hook, ok := c.typeHooks[base]; ok {
+		parsed, err := hook(s)
+		if err != nil {
+			return nil, err
+		}
+		pv := reflect.ValueOf(parsed)
+		if !pv.IsValid() || pv.Type() != base {
+			return nil, fmt.Errorf("parser of type %s returned %T", base, parsed)
+		}
+		v.Elem().Set(pv)
+	} else if err := v.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+		return nil, err
+	}
+
+	if t.Kind() == reflect.Ptr {
+		return v.Interface(), nil
+	}
+	return v.Elem().Interface(), nil
+}
+
+// parseValue parses s into a value of a custom or a scalar type
+func (c *ConfReader) parseValue(s string, t reflect.Type) (interface{}, error) {
+	if c.isCustomType(t) {
+		return c.parseCustom(s, t)
+	}
+	return parseScalar(s, t)
+}
+
+// decodeCustomType is a decode hook that parses string values from the config file into fields of custom types
+func (c *ConfReader) decodeCustomType(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	s, ok := data.(string)
+	if !ok || !c.isCustomType(to) {
+		return data, nil
+	}
+	return c.parseCustom(s, to)
+}
+
+// textValue is a flag value for fields of custom types
+type textValue struct {
+	typ   reflect.Type
+	parse parseFunc
+	value interface{}
+}
+
+func newTextValue(t reflect.Type, parse parseFunc) *textValue {
+	return &textValue{typ: t, parse: parse}
+}
+
+func (v *textValue) Set(s string) error {
+	parsed, err := v.parse(s, v.typ)
+	if err != nil {
+		return err
+	}
+	v.value = parsed
+	return nil
+}
+
+func (v *textValue) Type() string {
+	return v.typ.String()
+}
+
+func (v *textValue) String() string {
+	if v.value == nil {
+		return ""
+	}
+	return formatValue(v.value)
+}
+
+// formatValue formats a value using encoding.TextMarshaler or fmt.Stringer if the value or a pointer to it implements them
+func formatValue(val interface{}) string {
+	rv := reflect.ValueOf(val)
+	if rv.Kind() != reflect.Ptr {
+		ptr := reflect.New(rv.Type())
+		ptr.Elem().Set(rv)
+		rv = ptr
+	} else if rv.IsNil() {
+		return ""
+	}
+
+	switch m := rv.Interface().(type) {
+	case encoding.TextMarshaler:
+		if b, err := m.MarshalText(); err == nil {
+			return string(b)
+		}
+	case fmt.Stringer:
+		return m.String()
+	}
+	return fmt.Sprint(val)
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Level int
+
+func (l *Level) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "debug":
+		*l = 0
+	case "info":
+		*l = 1
+	case "error":
+		*l = 2
+	default:
+		return fmt.Errorf("unknown level %q", text)
+	}
+	return nil
+}
+
+type Point struct {
+	X, Y int
+}
+
+func parsePoint(s string) (Point, error) {
+	var p Point
+	_, err := fmt.Sscanf(s, "%d:%d", &p.X, &p.Y)
+	return p, err
+}
+
+type CustomTypesConfig struct {
+	IP      net.IP
+	URL     *url.URL
+	Started time.Time
+	Big     *big.Int
+	Level   Level
+	Levels  []Level
+	Origin  Point
+	Points  map[string]Point
+}
+
+func Test_CustomTypes(t *testing.T) {
+	fsys := fstest.MapFS{"custom.yaml": {Data: []byte(`
+ip: 10.0.0.1
+url: https://example.com/file
+started: "2022-01-02T03:04:05Z"
+big: "123456789012345678901234567890"
+level: info
+levels: [debug, error]
+origin: "1:2"
+points:
+  a: "3:4"
+`)}}
+
+	newReader := func() *ConfReader {
+		return RegisterType(NewConfReader("custom"), parsePoint).WithFS(fsys)
+	}
+
+	t.Run("file", func(t *testing.T) {
+		t.Parallel()
+		cfg := &CustomTypesConfig{}
+		err := newReader().WithArgs(nil).WithEnv(nil).Read(cfg)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "10.0.0.1", cfg.IP.String())
+			assert.Equal(t, "https://example.com/file", cfg.URL.String())
+			assert.Equal(t, time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC), cfg.Started)
+			assert.Equal(t, "123456789012345678901234567890", cfg.Big.String())
+			assert.Equal(t, Level(1), cfg.Level)
+			assert.Equal(t, []Level{0, 2}, cfg.Levels)
+			assert.Equal(t, Point{1, 2}, cfg.Origin)
+			assert.Equal(t, map[string]Point{"a": {3, 4}}, cfg.Points)
+		}
+	})
+
+	t.Run("env", func(t *testing.T) {
+		t.Parallel()
+		cfg := &CustomTypesConfig{}
+		err := newReader().WithArgs(nil).WithEnv(map[string]string{
+			"IP":     "10.0.0.2",
+			"URL":    "http://localhost",
+			"LEVEL":  "error",
+			"LEVELS": "info",
+			"ORIGIN": "5:6",
+			"POINTS": "b=7:8",
+		}).Read(cfg)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "10.0.0.2", cfg.IP.String())
+			assert.Equal(t, "http://localhost", cfg.URL.String())
+			assert.Equal(t, Level(2), cfg.Level)
+			assert.Equal(t, []Level{1}, cfg.Levels)
+			assert.Equal(t, Point{5, 6}, cfg.Origin)
+			assert.Equal(t, map[string]Point{"a": {3, 4}, "b": {7, 8}}, cfg.Points)
+		}
+	})
+
+	t.Run("flags", func(t *testing.T) {
+		t.Parallel()
+		cfg := &CustomTypesConfig{}
+		err := newReader().WithEnv(nil).WithArgs([]string{
+			"--ip", "::1", "--started", "2023-01-01T00:00:00Z", "--big", "42", "--level", "debug", "--origin", "0:1",
+		}).Read(cfg)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "::1", cfg.IP.String())
+			assert.Equal(t, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), cfg.Started)
+			assert.Equal(t, "42", cfg.Big.String())
+			assert.Equal(t, Level(0), cfg.Level)
+			assert.Equal(t, Point{0, 1}, cfg.Origin)
+		}
+	})
+
+	t.Run("errorsNameTheField", func(t *testing.T) {
+		t.Parallel()
+		err := newReader().WithArgs(nil).WithEnv(map[string]string{"LEVEL": "loud"}).Read(&CustomTypesConfig{})
+		var envErr *EnvError
+		if assert.True(t, errors.As(err, &envErr)) {
+			assert.Equal(t, "level", envErr.Field)
+		}
+
+		err = newReader().WithEnv(nil).WithArgs([]string{"--ip", "nope"}).Read(&CustomTypesConfig{})
+		var flagErr *FlagError
+		if assert.True(t, errors.As(err, &flagErr)) {
+			assert.Equal(t, "ip", flagErr.Field)
+		}
+	})
+
+	t.Run("loadOption", func(t *testing.T) {
+		t.Parallel()
+		cfg, err := Load[CustomTypesConfig]("custom", WithType(parsePoint), WithFS(fsys), WithArgs([]string{"--origin", "9:9"}), WithEnv(nil))
+		if assert.NoError(t, err) {
+			assert.Equal(t, Point{9, 9}, cfg.Origin)
+			assert.Equal(t, map[string]Point{"a": {3, 4}}, cfg.Points)
+		}
+	})
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// validatable is implemented by the config struct and nested structs that check their values themselves,
+// e.g. rules that involve several fields
+type validatable interface {
+	Validate() error
+}
+
+// WithValidator sets the validator that checks `validate` tags, e.g. with custom tags or aliases registered.
+func (c *ConfReader) WithValidator(v *validator.Validate) *ConfReader {
+	c.validation = v
+	return c
+}
+
+// RegisterValidation registers a custom validation for the tag, e.g. `validate:"k8sname"`. See validator.Validate.RegisterValidation.
+func (c *ConfReader) RegisterValidation(tag string, fn validator.Func) error {
+	return c.validator().RegisterValidation(tag, fn)
+}
+
+// validator returns the validator set by WithValidator or creates a new one
+func (c *ConfReader) validator() *validator.Validate {
+	if c.validation == nil {
+		c.validation = validator.New()
+	}
+	return c.validation
+}
+
+// validate validates the struct by `validate` tags and then calls Validate methods of the struct and nested structs.
+// prefix is the key of the struct, it is empty for the config struct.
+// Failed fields are reported by their config keys, env vars and flags from fields.
+func (c *ConfReader) validate(configStruct interface{}, prefix string, fields map[string]*flagInfo) error {
+	err := c.validator().Struct(configStruct)
+	if err == nil {
+		return c.callValidate(configStruct, prefix)
+	}
+	validationErrors, ok := err.(validator.ValidationErrors)
+	if !ok || len(validationErrors) == 0 {
+		return err
+	}
+
+	res := &ValidationError{Err: validationErrors}
+	t := reflect.TypeOf(configStruct)
+	for _, fe := range validationErrors {
+		key := fieldKey(t, fe.StructNamespace())
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		f := FieldError{Field: key, Rule: fe.Tag(), Param: fe.Param(), Value: fe.Value()}
+		info, ok := fields[key]
+		if !ok {
+			info = &flagInfo{}
+		}
+		f.Env = c.envVarNames(key, info)[0]
+		if ok && info.Arg == "" {
+			f.Flag = info.Name
+		}
+		res.Fields = append(res.Fields, f)
+	}
+	return res
+}
+
+// namespaceRe matches a field name followed by indexes or map keys in validator namespaces like "Config.Upstreams[0].Host"
+var namespaceRe = regexp.MustCompile(`([^.\[\]]+)((?:\[[^\]]*\])*)`)
+
+// fieldKey converts a validator namespace with Go field names into a config key like "upstreams.0.host".
+// The first part of the namespace is the name of the validated struct type.
+func fieldKey(t reflect.Type, namespace string) string {
+	var parts []string
+	for i, m := range namespaceRe.FindAllStringSubmatch(namespace, -1) {
+		if i == 0 {
+			continue
+		}
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		squash := false
+		if t.Kind() == reflect.Struct {
+			if f, ok := t.FieldByName(m[1]); ok {
+				squash = strings.Contains(f.Tag.Get("mapstructure"), "squash")
+				t = f.Type
+			}
+		}
+		if !squash {
+			parts = append(parts, strings.ToLower(m[1]))
+		}
+		for _, idx := range strings.Split(strings.Trim(m[2], "[]"), "][") {
+			if idx == "" {
+				continue
+			}
+			parts = append(parts, strings.ToLower(idx))
+			for t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			if t.Kind() == reflect.Slice || t.Kind() == reflect.Map || t.Kind() == reflect.Array {
+				t = t.Elem()
+			}
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// callValidate calls Validate methods of the struct and values nested in it. Nested values are validated first,
+// so the method of a struct could rely on valid fields.
+func (c *ConfReader) callValidate(configStruct interface{}, prefix string) error {
+	var fields []FieldError
+	c.walkValidate(reflect.ValueOf(configStruct), prefix, true, &fields)
+	if len(fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: fields, Err: fields[0].Err}
+}
+
+// walkValidate adds errors of Validate methods of the value and values nested in it to errs. key is the config key of the value.
+// hook is false for embedded structs since their methods are promoted to the struct that embeds them.
+func (c *ConfReader) walkValidate(v reflect.Value, key string, hook bool, errs *[]FieldError) {
+	join := func(k string) string {
+		return strings.TrimPrefix(key+"."+strings.ToLower(k), ".")
+	}
+
+	hookValue := v
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		hookValue, v = v, v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if c.isCustomType(v.Type()) {
+			break
+		}
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			if f.Anonymous || strings.Contains(f.Tag.Get("mapstructure"), "squash") {
+				c.walkValidate(v.Field(i), key, !f.Anonymous, errs)
+			} else {
+				c.walkValidate(v.Field(i), join(f.Name), true, errs)
+			}
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			c.walkValidate(v.Index(i), join(fmt.Sprint(i)), true, errs)
+		}
+
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+		for _, k := range keys {
+			c.walkValidate(v.MapIndex(k), join(fmt.Sprint(k)), true, errs)
+		}
+	}
+
+	if !hook || !hookValue.CanInterface() {
+		return
+	}
+	val, ok := hookValue.Interface().(validatable)
+	if !ok && hookValue.CanAddr() {
+		val, ok = hookValue.Addr().Interface().(validatable)
+	}
+	if ok {
+		if err := val.Validate(); err != nil {
+			*errs = append(*errs, FieldError{Field: key, Err: err})
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+)
+
+type ValidatedUpstream struct {
+	Host string `validate:"required"`
+}
+
+type ValidatedConfig struct {
+	Db struct {
+		Host     string `validate:"required"`
+		Port     int    `validate:"max=65535" flag:"dbport"`
+		Password string `validate:"required" envvar:"DB_PASS"`
+	}
+	Upstreams []ValidatedUpstream `validate:"dive"`
+}
+
+func Test_ValidationError(t *testing.T) {
+	fsys := fstest.MapFS{"validated.yaml": {Data: []byte("upstreams:\n  - host: a\n  - port: 1\n")}}
+	err := NewConfReader("validated").WithPrefix("APP").WithFS(fsys).WithArgs([]string{"--dbport", "70000"}).WithEnv(nil).
+		Read(&ValidatedConfig{})
+
+	var validationErr *ValidationError
+	if !assert.ErrorAs(t, err, &validationErr) {
+		return
+	}
+	assert.Equal(t, []FieldError{
+		{Field: "db.host", Env: "APP_DB_HOST", Flag: "db.host", Rule: "required", Value: ""},
+		{Field: "db.port", Env: "APP_DB_PORT", Flag: "dbport", Rule: "max", Param: "65535", Value: 70000},
+		{Field: "db.password", Env: "DB_PASS", Flag: "db.password", Rule: "required", Value: ""},
+		{Field: "upstreams.1.host", Env: "APP_UPSTREAMS_1_HOST", Flag: "upstreams.1.host", Rule: "required", Value: ""},
+	}, validationErr.Fields)
+
+	assert.Equal(t, `invalid config:
+  db.host: failed on "required" (env APP_DB_HOST, flag --db.host)
+  db.port: failed on "max=65535" (env APP_DB_PORT, flag --dbport)
+  db.password: failed on "required" (env DB_PASS, flag --db.password)
+  upstreams.1.host: failed on "required" (env APP_UPSTREAMS_1_HOST, flag --upstreams.1.host)`, validationErr.Details())
+	// the error names fields by config keys, not by Go field names
+	assert.Contains(t, err.Error(), `validation error: db.host: failed on "required" (env APP_DB_HOST, flag --db.host); db.port:`)
+	assert.NotContains(t, err.Error(), "ValidatedConfig.Db.Host")
+	// values could be secrets, so they are only available in Fields
+	assert.NotContains(t, err.Error(), "70000")
+}
+
+type HookedDb struct {
+	Host   string
+	Socket string
+}
+
+func (d HookedDb) Validate() error {
+	if d.Host == "" && d.Socket == "" {
+		return errors.New("host or socket must be set")
+	}
+	return nil
+}
+
+type HookedTenant struct {
+	Quota int
+}
+
+func (t *HookedTenant) Validate() error {
+	if t.Quota < 0 {
+		return errors.New("quota must not be negative")
+	}
+	return nil
+}
+
+type HookedConfig struct {
+	Name    string `validate:"k8sname"`
+	Db      HookedDb
+	Tenants []HookedTenant
+	calls   *[]string
+}
+
+func (c *HookedConfig) Validate() error {
+	if c.calls != nil {
+		*c.calls = append(*c.calls, "root")
+	}
+	if c.Name == "forbidden" {
+		return errors.New("name is forbidden")
+	}
+	return nil
+}
+
+var k8sNameRe = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+func isK8sName(fl validator.FieldLevel) bool {
+	return k8sNameRe.MatchString(fl.Field().String())
+}
+
+func Test_CustomValidation(t *testing.T) {
+	read := func(reader *ConfReader, env map[string]string) error {
+		return reader.WithFS(fstest.MapFS{}).WithArgs([]string{}).WithEnv(env).Read(&HookedConfig{})
+	}
+
+	t.Run("registerValidation", func(t *testing.T) {
+		reader := NewConfReader("hooked")
+		assert.NoError(t, reader.RegisterValidation("k8sname", isK8sName))
+		err := read(reader, map[string]string{"NAME": "Not_Valid", "DB_HOST": "h"})
+
+		var validationErr *ValidationError
+		if assert.ErrorAs(t, err, &validationErr) {
+			assert.Equal(t, []FieldError{{Field: "name", Env: "NAME", Flag: "name", Rule: "k8sname", Value: "Not_Valid"}}, validationErr.Fields)
+		}
+		assert.NoError(t, read(reader, map[string]string{"NAME": "valid-name", "DB_HOST": "h"}))
+	})
+
+	t.Run("withValidator", func(t *testing.T) {
+		v := validator.New()
+		v.RegisterAlias("k8sname", "lowercase")
+		err := read(NewConfReader("hooked").WithValidator(v), map[string]string{"NAME": "UPPER", "DB_HOST": "h"})
+		assert.ErrorContains(t, err, `name: failed on "k8sname" (env NAME, flag --name)`)
+		assert.NotContains(t, err.Error(), "UPPER")
+	})
+
+	t.Run("validateMethods", func(t *testing.T) {
+		reader := NewConfReader("hooked")
+		assert.NoError(t, reader.RegisterValidation("k8sname", isK8sName))
+		err := read(reader, map[string]string{"NAME": "forbidden", "TENANTS_0_QUOTA": "1", "TENANTS_1_QUOTA": "-1"})
+
+		var validationErr *ValidationError
+		if assert.ErrorAs(t, err, &validationErr) {
+			assert.Equal(t, []string{
+				"db: host or socket must be set",
+				"tenants.1: quota must not be negative",
+				"name is forbidden",
+			}, fieldStrings(validationErr.Fields))
+			assert.Equal(t, "validation error: db: host or socket must be set; tenants.1: quota must not be negative; name is forbidden", err.Error())
+		}
+	})
+
+	t.Run("methodsAfterTags", func(t *testing.T) {
+		var calls []string
+		cf := &HookedConfig{calls: &calls}
+		reader := NewConfReader("hooked").WithFS(fstest.MapFS{}).WithArgs([]string{}).WithEnv(map[string]string{"NAME": "Not_Valid", "DB_HOST": "h"})
+		assert.NoError(t, reader.RegisterValidation("k8sname", isK8sName))
+		assert.Error(t, reader.Read(cf))
+		assert.Empty(t, calls)
+	})
+}
+
+func fieldStrings(fields []FieldError) []string {
+	res := make([]string, 0, len(fields))
+	for _, f := range fields {
+		res = append(res, f.String())
+	}
+	return res
+}
@@ -0,0 +1,312 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// parseScalar parses s into a value of type t. Only basic types and time.Duration are supported.
+func parseScalar(s string, t reflect.Type) (interface{}, error) {
+	if t == durationType {
+		return time.ParseDuration(s)
+	}
+
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 0, t.Bits())
+		if err != nil {
+			return nil, err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 0, t.Bits())
+		if err != nil {
+			return nil, err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, t.Bits())
+		if err != nil {
+			return nil, err
+		}
+		v.SetFloat(f)
+	default:
+		return nil, fmt.Errorf("unsupported type %s", t)
+	}
+	return v.Interface(), nil
+}
+
+// isScalar tells if parseScalar supports the type
+func isScalar(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// isScalarPtr tells if the type is a pointer to a scalar value, like *int or *time.Duration.
+// Such fields are set the same way as scalars, but stay nil if no source sets them.
+func isScalarPtr(t reflect.Type) bool {
+	return t.Kind() == reflect.Ptr && isScalar(t.Elem())
+}
+
+// valueType returns the type of values that set the field: the scalar type for pointers to scalars, the type itself otherwise
+func valueType(t reflect.Type) reflect.Type {
+	if isScalarPtr(t) {
+		return t.Elem()
+	}
+	return t
+}
+
+// isScalarSlice tells if the type is a slice of scalar or custom values, like []int. Byte slices are not included
+// since they are set as base64 strings.
+func (c *ConfReader) isScalarSlice(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8 && (isScalar(t.Elem()) || c.isCustomType(t.Elem()))
+}
+
+// isScalarMap tells if the type is a map with string keys and scalar or custom values, like map[string]int
+func (c *ConfReader) isScalarMap(t reflect.Type) bool {
+	return t.Kind() == reflect.Map && t.Key().Kind() == reflect.String && (isScalar(t.Elem()) || c.isCustomType(t.Elem()))
+}
+
+// formatDefault parses the `default` tag of a field and formats it the way the flag of the field prints its value.
+// Slices and maps are set in JSON, the same way github.com/creasty/defaults sets them.
+func (c *ConfReader) formatDefault(info *flagInfo) (string, error) {
+	t := valueType(info.Type)
+	switch {
+	case c.isCustomType(t):
+		v, err := c.parseCustom(info.DefaultVal, t)
+		if err != nil {
+			return "", err
+		}
+		return formatValue(v), nil
+
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		var b []byte
+		if err := json.Unmarshal([]byte(info.DefaultVal), &b); err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(b), nil
+
+	case c.isScalarSlice(t):
+		v := reflect.New(t)
+		if err := json.Unmarshal([]byte(info.DefaultVal), v.Interface()); err != nil {
+			return "", err
+		}
+		sv := newSliceValue(t, c.parseValue)
+		for i := 0; i < v.Elem().Len(); i++ {
+			sv.value = append(sv.value, v.Elem().Index(i).Interface())
+		}
+		return sv.String(), nil
+
+	case c.isScalarMap(t):
+		v := reflect.New(t)
+		if err := json.Unmarshal([]byte(info.DefaultVal), v.Interface()); err != nil {
+			return "", err
+		}
+		m := newMapValue(t, c.parseValue)
+		iter := v.Elem().MapRange()
+		for iter.Next() {
+			m.value[iter.Key().String()] = iter.Value().Interface()
+		}
+		return m.String(), nil
+
+	case isScalar(t):
+		v, err := parseScalar(info.DefaultVal, t)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprint(v), nil
+	}
+
+	if t.Kind() == reflect.Map || t.Kind() == reflect.Slice {
+		// other maps and slices are not set by flags, but the tag still has to be valid JSON
+		if err := json.Unmarshal([]byte(info.DefaultVal), reflect.New(t).Interface()); err != nil {
+			return "", err
+		}
+	}
+	return info.DefaultVal, nil
+}
+
+// parseFunc parses a string into a value of the type
+type parseFunc func(s string, t reflect.Type) (interface{}, error)
+
+// readCSV splits comma separated values, values with commas could be quoted
+func readCSV(val string) ([]string, error) {
+	if val == "" {
+		return []string{}, nil
+	}
+	return csv.NewReader(strings.NewReader(val)).Read()
+}
+
+// sliceValue is a flag value for slices of scalar values. Values could be comma separated or set by repeating the flag.
+// It implements pflag.SliceValue.
+type sliceValue struct {
+	typ       reflect.Type
+	parseElem parseFunc
+	value     []interface{}
+	changed   bool
+}
+
+func newSliceValue(t reflect.Type, parseElem parseFunc) *sliceValue {
+	return &sliceValue{typ: t, parseElem: parseElem, value: []interface{}{}}
+}
+
+func (s *sliceValue) Set(val string) error {
+	items, err := readCSV(val)
+	if err != nil {
+		return err
+	}
+	parsed, err := s.parse(items)
+	if err != nil {
+		return err
+	}
+
+	// the first value replaces the default one, the following ones are appended
+	if !s.changed {
+		s.value = parsed
+	} else {
+		s.value = append(s.value, parsed...)
+	}
+	s.changed = true
+	return nil
+}
+
+func (s *sliceValue) parse(items []string) ([]interface{}, error) {
+	res := make([]interface{}, 0, len(items))
+	for i, item := range items {
+		v, err := s.parseElem(strings.TrimSpace(item), s.typ.Elem())
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of element %d: %w", i, err)
+		}
+		res = append(res, v)
+	}
+	return res, nil
+}
+
+func (s *sliceValue) Type() string {
+	return s.typ.String()
+}
+
+func (s *sliceValue) String() string {
+	return "[" + strings.Join(s.GetSlice(), ",") + "]"
+}
+
+func (s *sliceValue) Append(val string) error {
+	parsed, err := s.parse([]string{val})
+	if err != nil {
+		return err
+	}
+	s.value = append(s.value, parsed...)
+	return nil
+}
+
+func (s *sliceValue) Replace(vals []string) error {
+	parsed, err := s.parse(vals)
+	if err != nil {
+		return err
+	}
+	s.value = parsed
+	return nil
+}
+
+func (s *sliceValue) GetSlice() []string {
+	res := make([]string, 0, len(s.value))
+	for _, v := range s.value {
+		res = append(res, fmt.Sprint(v))
+	}
+	return res
+}
+
+// mapValue is a flag value for maps with string keys in form of "k1=v1,k2=v2".
+// Every Set adds keys to the map, so the flag could be repeated.
+type mapValue struct {
+	typ       reflect.Type
+	parseElem parseFunc
+	value     map[string]interface{}
+}
+
+func newMapValue(t reflect.Type, parseElem parseFunc) *mapValue {
+	return &mapValue{typ: t, parseElem: parseElem, value: map[string]interface{}{}}
+}
+
+func (m *mapValue) Set(val string) error {
+	var pairs []string
+	if strings.Count(val, "=") == 1 {
+		pairs = []string{strings.Trim(val, `"`)}
+	} else {
+		var err error
+		pairs, err = readCSV(val)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("%s must be formatted as key=value", pair)
+		}
+		v, err := m.parseElem(kv[1], m.typ.Elem())
+		if err != nil {
+			return fmt.Errorf("invalid value for key %s: %w", kv[0], err)
+		}
+		m.value[kv[0]] = v
+	}
+	return nil
+}
+
+func (m *mapValue) Type() string {
+	return m.typ.String()
+}
+
+func (m *mapValue) String() string {
+	keys := make([]string, 0, len(m.value))
+	for k := range m.value {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", k, m.value[k]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// mergeMap adds values to a copy of the current value of a map field, so values from different sources are merged
+// instead of replacing each other
+func mergeMap(current interface{}, values map[string]interface{}) map[string]interface{} {
+	res := map[string]interface{}{}
+	for k, v := range cast.ToStringMap(current) {
+		res[k] = v
+	}
+	for k, v := range values {
+		res[k] = v
+	}
+	return res
+}
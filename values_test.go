@@ -0,0 +1,225 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type MapConfig struct {
+	Labels   map[string]string
+	Limits   map[string]int
+	Timeouts map[string]time.Duration `flag:"timeouts"`
+}
+
+func Test_Maps(t *testing.T) {
+	fsys := fstest.MapFS{"maps.yaml": {Data: []byte("labels:\n  fromfile: a\n  overridden: file\nlimits:\n  cpu: 2\n")}}
+
+	t.Run("mergesAllSources", func(t *testing.T) {
+		t.Parallel()
+		cfg := &MapConfig{}
+		err := NewConfReader("maps").WithFS(fsys).
+			WithEnv(map[string]string{"LABELS": "fromenv=b,overridden=env", "TIMEOUTS": "read=5s"}).
+			WithArgs([]string{"--labels", "fromflag=c", "--labels=second=d", "--limits", "mem=512", "--timeouts", "write=1m"}).
+			Read(cfg)
+		if assert.NoError(t, err) {
+			assert.Equal(t, map[string]string{
+				"fromfile":   "a",
+				"fromenv":    "b",
+				"overridden": "env",
+				"fromflag":   "c",
+				"second":     "d",
+			}, cfg.Labels)
+			assert.Equal(t, map[string]int{"cpu": 2, "mem": 512}, cfg.Limits)
+			assert.Equal(t, map[string]time.Duration{"read": 5 * time.Second, "write": time.Minute}, cfg.Timeouts)
+		}
+	})
+
+	t.Run("invalidEnvValue", func(t *testing.T) {
+		t.Parallel()
+		cfg := &MapConfig{}
+		err := NewConfReader("maps").WithFS(fsys).WithArgs(nil).
+			WithEnv(map[string]string{"LIMITS": "cpu=lots"}).
+			Read(cfg)
+		var envErr *EnvError
+		if assert.True(t, errors.As(err, &envErr)) {
+			assert.Equal(t, "LIMITS", envErr.Var)
+			assert.Equal(t, "limits", envErr.Field)
+		}
+	})
+
+	t.Run("invalidFlagValue", func(t *testing.T) {
+		t.Parallel()
+		cfg := &MapConfig{}
+		err := NewConfReader("maps").WithFS(fsys).WithEnv(nil).
+			WithArgs([]string{"--timeouts", "read"}).
+			Read(cfg)
+		var flagErr *FlagError
+		if assert.True(t, errors.As(err, &flagErr)) {
+			assert.Equal(t, FlagErrorInvalidValue, flagErr.Kind)
+			assert.Equal(t, "timeouts", flagErr.Flag)
+		}
+	})
+}
+
+type SliceTypesConfig struct {
+	Ints      []int
+	Floats    []float64
+	Bools     []bool
+	Durations []time.Duration
+	Uints     []uint
+	Int8s     []int8
+	Strings   []string
+}
+
+func Test_SliceTypes(t *testing.T) {
+	t.Run("flags", func(t *testing.T) {
+		t.Parallel()
+		cfg := &SliceTypesConfig{}
+		err := NewConfReader("slices").WithFS(fstest.MapFS{}).WithEnv(nil).
+			WithArgs([]string{"--ints", "1,2", "--ints", "3", "--floats", "1.5", "--bools", "true,false",
+				"--durations", "1s,1m", "--uints", "7", "--int8s", "-1", "--strings", "a,b"}).
+			Read(cfg)
+		if assert.NoError(t, err) {
+			assert.Equal(t, []int{1, 2, 3}, cfg.Ints)
+			assert.Equal(t, []float64{1.5}, cfg.Floats)
+			assert.Equal(t, []bool{true, false}, cfg.Bools)
+			assert.Equal(t, []time.Duration{time.Second, time.Minute}, cfg.Durations)
+			assert.Equal(t, []uint{7}, cfg.Uints)
+			assert.Equal(t, []int8{-1}, cfg.Int8s)
+			assert.Equal(t, []string{"a", "b"}, cfg.Strings)
+		}
+	})
+
+	t.Run("env", func(t *testing.T) {
+		t.Parallel()
+		cfg := &SliceTypesConfig{}
+		err := NewConfReader("slices").WithFS(fstest.MapFS{}).WithArgs(nil).
+			WithEnv(map[string]string{"INTS": "1, 2", "DURATIONS": "5s", "BOOLS": "true"}).
+			Read(cfg)
+		if assert.NoError(t, err) {
+			assert.Equal(t, []int{1, 2}, cfg.Ints)
+			assert.Equal(t, []time.Duration{5 * time.Second}, cfg.Durations)
+			assert.Equal(t, []bool{true}, cfg.Bools)
+		}
+	})
+
+	t.Run("envError", func(t *testing.T) {
+		t.Parallel()
+		cfg := &SliceTypesConfig{}
+		err := NewConfReader("slices").WithFS(fstest.MapFS{}).WithArgs(nil).
+			WithEnv(map[string]string{"UINTS": "1,-2"}).
+			Read(cfg)
+		var envErr *EnvError
+		if assert.True(t, errors.As(err, &envErr)) {
+			assert.Equal(t, "uints", envErr.Field)
+			assert.Contains(t, err.Error(), "element 1")
+		}
+	})
+
+	t.Run("flagError", func(t *testing.T) {
+		t.Parallel()
+		cfg := &SliceTypesConfig{}
+		err := NewConfReader("slices").WithFS(fstest.MapFS{}).WithEnv(nil).
+			WithArgs([]string{"--floats", "1.5,x"}).
+			Read(cfg)
+		var flagErr *FlagError
+		if assert.True(t, errors.As(err, &flagErr)) {
+			assert.Equal(t, FlagErrorInvalidValue, flagErr.Kind)
+			assert.Equal(t, "floats", flagErr.Field)
+			assert.Equal(t, "1.5,x", flagErr.Value)
+		}
+	})
+}
+
+type PointerConfig struct {
+	Timeout *time.Duration
+	Enabled *bool
+	Retries *int `default:"3"`
+	Name    *string
+	Ratio   *float64
+	Db      struct {
+		Port *uint16 `flag:"port"`
+	}
+}
+
+func Test_PointerFields(t *testing.T) {
+	t.Run("unset", func(t *testing.T) {
+		cf := &PointerConfig{}
+		err := NewConfReader("pointers").WithArgs([]string{}).WithEnv(map[string]string{"NAME": ""}).WithFS(fstest.MapFS{}).Read(cf)
+		if assert.NoError(t, err) {
+			assert.Nil(t, cf.Timeout)
+			assert.Nil(t, cf.Enabled)
+			assert.Nil(t, cf.Name)
+			assert.Nil(t, cf.Ratio)
+			assert.Nil(t, cf.Db.Port)
+			if assert.NotNil(t, cf.Retries) {
+				assert.Equal(t, 3, *cf.Retries)
+			}
+		}
+	})
+
+	t.Run("set", func(t *testing.T) {
+		cf := &PointerConfig{}
+		fsys := fstest.MapFS{"pointers.yaml": {Data: []byte("name: fromfile\nretries: 5\n")}}
+		err := NewConfReader("pointers").
+			WithArgs([]string{"--timeout", "5s", "--enabled=false", "--port", "5432"}).
+			WithEnv(map[string]string{"RATIO": "0.5", "RETRIES": "0"}).
+			WithFS(fsys).
+			Read(cf)
+		if assert.NoError(t, err) {
+			if assert.NotNil(t, cf.Timeout) {
+				assert.Equal(t, 5*time.Second, *cf.Timeout)
+			}
+			// zero values are set, not treated as missing
+			if assert.NotNil(t, cf.Enabled) {
+				assert.False(t, *cf.Enabled)
+			}
+			if assert.NotNil(t, cf.Retries) {
+				assert.Equal(t, 0, *cf.Retries)
+			}
+			if assert.NotNil(t, cf.Name) {
+				assert.Equal(t, "fromfile", *cf.Name)
+			}
+			if assert.NotNil(t, cf.Ratio) {
+				assert.Equal(t, 0.5, *cf.Ratio)
+			}
+			if assert.NotNil(t, cf.Db.Port) {
+				assert.Equal(t, uint16(5432), *cf.Db.Port)
+			}
+		}
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		env := map[string]string{}
+		cf := &PointerConfig{}
+		reader := NewConfReader("pointers").WithArgs([]string{}).WithFS(fstest.MapFS{}).WithEnvLookup(func(name string) (string, bool) {
+			v, ok := env[name]
+			return v, ok
+		})
+		if !assert.NoError(t, reader.Read(cf)) {
+			return
+		}
+
+		var changes []FieldChange
+		reader.OnChange(func(_, _ interface{}, c []FieldChange) {
+			changes = c
+		})
+		env["TIMEOUT"] = "1m"
+		if assert.NoError(t, reader.Reload()) {
+			assert.Equal(t, []FieldChange{{Field: "timeout", Old: nil, New: time.Minute}}, changes)
+		}
+	})
+
+	t.Run("invalidFlag", func(t *testing.T) {
+		err := NewConfReader("pointers").WithArgs([]string{"--timeout", "soon"}).WithEnv(nil).WithFS(fstest.MapFS{}).Read(&PointerConfig{})
+		var flagErr *FlagError
+		if assert.ErrorAs(t, err, &flagErr) {
+			assert.Equal(t, FlagErrorInvalidValue, flagErr.Kind)
+			assert.Equal(t, "timeout", flagErr.Field)
+		}
+	})
+}
@@ -0,0 +1,383 @@
+package config
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// FieldChange describes a config field which value was changed by reload.
+// Field is a path of the field like "db.maxconns".
+type FieldChange struct {
+	Field string
+	Old   interface{}
+	New   interface{}
+}
+
+// Watch watches for config changes and reloads config. This method should be called after Read() to make sure that ConfReader konws which struct to reload.
+// Returns a mutex that can be used to synchronize access to the config.
+// If you care about thread safety, call RLock() on the mutex while accessing the config and the RUnlock().
+// This will ensure that the config is not reloaded while you are accessing it.
+// Watching never stops, use WatchContext to be able to stop it.
+func (c *ConfReader) Watch() *sync.RWMutex {
+	if c.configStruct == nil {
+		panic("ConfReader: config struct is not set. Call Read before Watch")
+	}
+
+	err := c.watch(context.Background(), func(err error) {
+		if !c.callErrorHandlers(err) {
+			log.Printf("failed to reload config: %s\n", err)
+		}
+	}, nil)
+	if err != nil {
+		log.Printf("failed to watch config: %s\n", err)
+	}
+	return &c.mu
+}
+
+// WithReloadOnSIGHUP makes Watch and WatchContext also reload config when the process receives SIGHUP.
+// It is useful when file changes are not noticed by the watcher, e.g. for files on NFS.
+// With this option watching does not fail if the config file can not be watched.
+func (c *ConfReader) WithReloadOnSIGHUP() *ConfReader {
+	c.reloadOnSIGHUP = true
+	return c
+}
+
+// WithPollInterval makes Watch and WatchContext also reload config every interval. It is useful for sources that
+// don't notify about changes, like environment that is changed at runtime or files that are not watched reliably.
+// Change handlers are called only if a reload actually changed the config.
+// With this option watching does not fail if the config file can not be watched.
+func (c *ConfReader) WithPollInterval(interval time.Duration) *ConfReader {
+	c.pollInterval = interval
+	return c
+}
+
+// WatchContext watches for config changes and reloads config until ctx is cancelled. It should be called after Read().
+// Use Mutex() to synchronize access to the config.
+//
+// Watching is done by a single goroutine which exits when ctx is cancelled.
+// Rejected reloads and watcher failures are sent to the returned channel, which is closed when the goroutine exits.
+// The channel must be drained, otherwise reloading is blocked until ctx is cancelled.
+func (c *ConfReader) WatchContext(ctx context.Context) (<-chan error, error) {
+	if c.configStruct == nil {
+		return nil, errors.New("config struct is not set. Call Read before WatchContext")
+	}
+
+	errs := make(chan error)
+	err := c.watch(ctx, func(err error) {
+		c.callErrorHandlers(err)
+		select {
+		case errs <- err:
+		case <-ctx.Done():
+		}
+	}, func() {
+		close(errs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// Mutex returns the mutex that is locked while reloaded config is applied. It is the same mutex that is returned by Watch.
+func (c *ConfReader) Mutex() *sync.RWMutex {
+	return &c.mu
+}
+
+// Reload re-reads config from the config file, env vars and flags into the struct passed to Read.
+// Environment is looked up again, so changed env vars are picked up as well.
+// It is useful when file changes can not be watched. Like reloads done by Watch, the config is changed only
+// if the new one is valid, otherwise *ReloadError is returned.
+func (c *ConfReader) Reload() error {
+	if c.configStruct == nil {
+		return errors.New("config struct is not set. Call Read before Reload")
+	}
+	return c.reload()
+}
+
+// watch starts a goroutine that reloads config when the config file changes, a reload signal is received or poll interval passes.
+// The goroutine exits when ctx is cancelled, onStop is called after that.
+func (c *ConfReader) watch(ctx context.Context, onError func(error), onStop func()) error {
+	// nil channels are never ready, so disabled triggers are simply ignored by select
+	var events chan fsnotify.Event
+	var watchErrors chan error
+	var signals chan os.Signal
+	var ticks <-chan time.Time
+
+	var configFile, realConfigFile string
+	watcher, err := c.newFileWatcher()
+	if err != nil {
+		if !c.reloadOnSIGHUP && c.pollInterval <= 0 {
+			return err
+		}
+		// config could still be reloaded by signal or polling
+	} else {
+		events, watchErrors = watcher.Events, watcher.Errors
+		configFile = filepath.Clean(c.configFile)
+		realConfigFile, _ = filepath.EvalSymlinks(configFile)
+	}
+
+	if c.reloadOnSIGHUP {
+		signals = make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGHUP)
+	}
+
+	var ticker *time.Ticker
+	if c.pollInterval > 0 {
+		ticker = time.NewTicker(c.pollInterval)
+		ticks = ticker.C
+	}
+
+	go func() {
+		defer func() {
+			if ticker != nil {
+				ticker.Stop()
+			}
+			if watcher != nil {
+				watcher.Close()
+			}
+			if signals != nil {
+				signal.Stop(signals)
+			}
+			if onStop != nil {
+				onStop()
+			}
+		}()
+
+		const writeOrCreateMask = fsnotify.Write | fsnotify.Create
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-signals:
+				if err := c.reload(); err != nil {
+					onError(err)
+				}
+
+			case <-ticks:
+				if err := c.reload(); err != nil {
+					onError(err)
+				}
+
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				currentConfigFile, _ := filepath.EvalSymlinks(configFile)
+				// we only care about the config file with the following cases:
+				// 1 - if the config file was modified or created
+				// 2 - if the real path to the config file changed (eg: k8s ConfigMap replacement)
+				if (filepath.Clean(event.Name) == configFile && event.Op&writeOrCreateMask != 0) ||
+					(currentConfigFile != "" && currentConfigFile != realConfigFile) {
+					realConfigFile = currentConfigFile
+					if err := c.reload(); err != nil {
+						onError(err)
+					}
+				}
+
+			case err, ok := <-watchErrors:
+				if !ok {
+					return
+				}
+				onError(errors.Wrap(err, "config watcher error"))
+			}
+		}
+	}()
+	return nil
+}
+
+// newFileWatcher creates a watcher for the directory of the config file
+func (c *ConfReader) newFileWatcher() (*fsnotify.Watcher, error) {
+	if c.fs != nil {
+		return nil, errors.New("config file from custom filesystem can not be watched")
+	}
+	if c.configFile == "" {
+		return nil, errors.New("config file not found")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create watcher")
+	}
+
+	// we have to watch the entire directory to pick up renames/atomic saves in a cross-platform way
+	configDir, _ := filepath.Split(filepath.Clean(c.configFile))
+	if err := watcher.Add(configDir); err != nil {
+		watcher.Close()
+		return nil, errors.Wrap(err, "failed to watch config dir")
+	}
+	return watcher, nil
+}
+
+// OnReloadError registers a function that is called when a reload is rejected because config could not be read or is invalid.
+// The config struct keeps the last valid values in that case. Watch logs errors if no function is registered.
+func (c *ConfReader) OnReloadError(fn func(err error)) {
+	c.handlersMu.Lock()
+	defer c.handlersMu.Unlock()
+	c.errorHandlers = append(c.errorHandlers, fn)
+}
+
+// callErrorHandlers returns false if there are no error handlers
+func (c *ConfReader) callErrorHandlers(err error) bool {
+	c.handlersMu.Lock()
+	handlers := append([]func(error){}, c.errorHandlers...)
+	c.handlersMu.Unlock()
+
+	for _, h := range handlers {
+		h(err)
+	}
+	return len(handlers) > 0
+}
+
+// OnChange registers a function that is called after a reload changed values of the config.
+// old and new are pointers to snapshots of the config struct before and after the reload, they must not be modified.
+// changes are sorted by field path.
+func (c *ConfReader) OnChange(fn func(old, new interface{}, changes []FieldChange)) {
+	c.handlersMu.Lock()
+	defer c.handlersMu.Unlock()
+	c.changeHandlers = append(c.changeHandlers, fn)
+}
+
+// OnChange is a typed version of ConfReader.OnChange. T should be the type of the struct passed to Read,
+// otherwise fn is never called.
+func OnChange[T any](c *ConfReader, fn func(old, new *T, changes []FieldChange)) {
+	c.OnChange(func(old, new interface{}, changes []FieldChange) {
+		o, ok := old.(*T)
+		if !ok {
+			return
+		}
+		fn(o, new.(*T), changes)
+	})
+}
+
+// reload reads config into a fresh copy of the config struct, validates it and only then copies it to the config struct passed to Read.
+// Only fields read from the config sources are copied, so unexported fields and fields set by the app after Read are kept.
+// If the new config is not valid, the config struct is not touched and *ReloadError is returned.
+// Change handlers are called if any value has changed.
+func (c *ConfReader) reload() error {
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+
+	live := reflect.ValueOf(c.configStruct)
+	fresh := reflect.New(live.Type().Elem())
+	res, err := c.read(fresh.Interface())
+	if err != nil {
+		return &ReloadError{Err: err}
+	}
+
+	old := reflect.New(live.Type().Elem())
+	updated := reflect.New(live.Type().Elem())
+	c.mu.Lock()
+	old.Elem().Set(live.Elem())
+	for _, info := range res.fields {
+		if info.Index != nil {
+			copyField(live.Elem(), fresh.Elem(), info.Index)
+		}
+	}
+	updated.Elem().Set(live.Elem())
+	c.provenance = res.trace.provenance()
+	res.setCommandConfig()
+	c.mu.Unlock()
+
+	changes := diffFields(res.fields, old, updated)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	c.handlersMu.Lock()
+	handlers := append([]func(old, new interface{}, changes []FieldChange){}, c.changeHandlers...)
+	c.handlersMu.Unlock()
+	for _, h := range handlers {
+		h(old.Interface(), updated.Interface(), changes)
+	}
+	return nil
+}
+
+// copyField sets the field of dst by its index path to the value of the same field of src.
+// A struct behind a nil pointer in src is treated as zero. Structs behind pointers in dst are copied before they are changed,
+// so snapshots that share them keep their values.
+func copyField(dst, src reflect.Value, index []int) {
+	if len(index) == 0 {
+		dst.Set(src)
+		return
+	}
+	if dst.Kind() == reflect.Ptr {
+		if !dst.CanSet() {
+			// pointers to unexported embedded structs can't be replaced
+			if !dst.IsNil() && !src.IsNil() {
+				copyField(dst.Elem(), src.Elem(), index)
+			}
+			return
+		}
+		if src.IsNil() && dst.IsNil() {
+			return
+		}
+		elem := reflect.New(dst.Type().Elem())
+		if !dst.IsNil() {
+			elem.Elem().Set(dst.Elem())
+		}
+		dst.Set(elem)
+		if src.IsNil() {
+			src = reflect.New(src.Type().Elem())
+		}
+		copyField(dst.Elem(), src.Elem(), index)
+		return
+	}
+	copyField(dst.Field(index[0]), src.Field(index[0]), index[1:])
+}
+
+// diffFields compares values of fields in two config structs
+func diffFields(fields map[string]*flagInfo, old, new reflect.Value) []FieldChange {
+	var changes []FieldChange
+	for k, info := range fields {
+		if info.Index == nil {
+			// fields of registered commands and of elements are not in the config struct
+			continue
+		}
+		o := fieldValue(old, info.Index)
+		n := fieldValue(new, info.Index)
+		if !reflect.DeepEqual(o, n) {
+			changes = append(changes, FieldChange{Field: k, Old: o, New: n})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// fieldValue returns value of the field by its index path. It returns nil if the field is behind a nil pointer
+// or is a nil pointer to a scalar.
+func fieldValue(v reflect.Value, index []int) interface{} {
+	for _, i := range index {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return nil
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	if !v.CanInterface() {
+		return nil
+	}
+	if isScalarPtr(v.Type()) {
+		// optional values are compared and reported by the values they point to
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	return v.Interface()
+}
@@ -0,0 +1,534 @@
+package config
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type WatchDb struct {
+	Host     string
+	MaxConns int
+	Replicas []string
+}
+
+type WatchConfig struct {
+	Db      WatchDb
+	Verbose bool
+}
+
+// writeFile replaces the file atomically so watcher never sees it half written
+func writeFile(t *testing.T, path string, data string) {
+	t.Helper()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_OnChange(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "watched.yaml")
+	writeFile(t, file, "db:\n  host: localhost\n  maxConns: 10\n  replicas: [a, b]\n")
+
+	cfg := &WatchConfig{}
+	reader := NewConfReader("watched").WithSearchDirs(dir).WithArgs(nil).WithEnv(nil)
+	if err := reader.Read(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	type event struct {
+		old, new *WatchConfig
+		changes  []FieldChange
+	}
+	events := make(chan event, 10)
+	OnChange(reader, func(old, new *WatchConfig, changes []FieldChange) {
+		events <- event{old, new, changes}
+	})
+	watchUntilCleanup(t, reader)
+
+	writeFile(t, file, "db:\n  host: localhost\n  maxConns: 20\n  replicas: [a, c]\n")
+
+	select {
+	case e := <-events:
+		assert.Equal(t, []FieldChange{
+			{Field: "db.maxconns", Old: 10, New: 20},
+			{Field: "db.replicas", Old: []string{"a", "b"}, New: []string{"a", "c"}},
+		}, e.changes)
+		assert.Equal(t, []string{"a", "b"}, e.old.Db.Replicas)
+		assert.Equal(t, 20, e.new.Db.MaxConns)
+		assert.Equal(t, 20, cfg.Db.MaxConns)
+	case <-time.After(2 * time.Second):
+		t.Fatal("change handler was not called")
+	}
+}
+
+// watchUntilCleanup watches config until the test finishes. Reload errors are only reported to OnReloadError handlers.
+func watchUntilCleanup(t *testing.T, reader *ConfReader) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	errs, err := reader.WatchContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range errs {
+		}
+	}()
+}
+
+type RollbackConfig struct {
+	Host     string `validate:"required"`
+	MaxConns int
+}
+
+func Test_ReloadRollback(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "rollback.yaml")
+	writeFile(t, file, "host: localhost\nmaxConns: 10\n")
+
+	cfg := &RollbackConfig{}
+	reader := NewConfReader("rollback").WithSearchDirs(dir).WithArgs(nil).WithEnv(nil)
+	if err := reader.Read(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 10)
+	changes := make(chan []FieldChange, 10)
+	reader.OnReloadError(func(err error) {
+		errs <- err
+	})
+	reader.OnChange(func(old, new interface{}, ch []FieldChange) {
+		changes <- ch
+	})
+	watchUntilCleanup(t, reader)
+	mutex := reader.Mutex()
+
+	t.Run("invalidConfigIsRejected", func(t *testing.T) {
+		// max conns is valid but host fails validation, so nothing should be applied
+		writeFile(t, file, "maxConns: 20\n")
+
+		select {
+		case err := <-errs:
+			var reloadErr *ReloadError
+			assert.ErrorAs(t, err, &reloadErr)
+			assert.Contains(t, err.Error(), "validation error")
+		case <-time.After(2 * time.Second):
+			t.Fatal("error handler was not called")
+		}
+
+		mutex.RLock()
+		assert.Equal(t, RollbackConfig{Host: "localhost", MaxConns: 10}, *cfg)
+		mutex.RUnlock()
+		port, _ := reader.Provenance("maxconns")
+		assert.Equal(t, 10, port.Source.Value)
+	})
+
+	t.Run("malformedFileIsRejected", func(t *testing.T) {
+		writeFile(t, file, "maxConns: [")
+
+		select {
+		case err := <-errs:
+			assert.Error(t, err)
+		case <-time.After(2 * time.Second):
+			t.Fatal("error handler was not called")
+		}
+		mutex.RLock()
+		assert.Equal(t, RollbackConfig{Host: "localhost", MaxConns: 10}, *cfg)
+		mutex.RUnlock()
+	})
+
+	t.Run("validConfigIsApplied", func(t *testing.T) {
+		writeFile(t, file, "host: db\nmaxConns: 30\n")
+
+		select {
+		case ch := <-changes:
+			assert.Len(t, ch, 2)
+		case <-time.After(2 * time.Second):
+			t.Fatal("change handler was not called")
+		}
+		mutex.RLock()
+		assert.Equal(t, RollbackConfig{Host: "db", MaxConns: 30}, *cfg)
+		mutex.RUnlock()
+	})
+}
+
+func Test_WatchHolder(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "holder.yaml")
+	writeFile(t, file, "db:\n  host: localhost\n  maxConns: 10\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reader := NewConfReader("holder").WithSearchDirs(dir).WithArgs(nil).WithEnv(nil)
+	holder, err := WatchHolder[WatchConfig](ctx, reader)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// handlers are called in order of registration, so holder is updated by the time this one is called
+	changed := make(chan struct{}, 10)
+	reader.OnChange(func(_, _ interface{}, _ []FieldChange) {
+		changed <- struct{}{}
+	})
+	first := holder.Load()
+	assert.Equal(t, 10, first.Db.MaxConns)
+
+	writeFile(t, file, "db:\n  host: localhost\n  maxConns: 20\n")
+	select {
+	case <-changed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("config was not reloaded")
+	}
+
+	assert.Equal(t, 20, holder.Load().Db.MaxConns)
+	// previous snapshot is immutable
+	assert.Equal(t, 10, first.Db.MaxConns)
+}
+
+func Test_WatchHolderReadError(t *testing.T) {
+	holder, err := WatchHolder[RollbackConfig](context.Background(), NewConfReader("holder-missing").WithFS(fstest.MapFS{}).WithArgs(nil).WithEnv(nil))
+	assert.Error(t, err)
+	assert.Nil(t, holder)
+}
+
+func Test_WatchHolderWatchError(t *testing.T) {
+	// the config is read from env vars only, so there is no file to watch
+	reader := NewConfReader("holder-nofile").WithSearchDirs(t.TempDir()).WithArgs(nil).
+		WithEnv(map[string]string{"HOST": "localhost"})
+	holder, err := WatchHolder[RollbackConfig](context.Background(), reader)
+	assert.EqualError(t, err, "config file not found")
+	assert.Nil(t, holder)
+	// no handler is left to update a holder that nobody has
+	assert.Empty(t, reader.changeHandlers)
+}
+
+func Test_HolderZeroValue(t *testing.T) {
+	var holder Holder[RollbackConfig]
+	assert.Nil(t, holder.Load())
+}
+
+func Test_ProvenanceDuringReload(t *testing.T) {
+	fsys := fstest.MapFS{"explain.yaml": {Data: []byte("host: localhost\nmaxConns: 10\n")}}
+	reader := NewConfReader("explain").WithFS(fsys).WithArgs(nil).WithEnv(nil)
+	if err := reader.Read(&RollbackConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			assert.NoError(t, reader.Reload())
+		}
+	}()
+	// run with -race: provenance is replaced by reloads while it is read
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		assert.Len(t, reader.Explain(), 2)
+		p, _ := reader.Provenance("host")
+		assert.Equal(t, "localhost", p.Source.Value)
+	}
+}
+
+func Test_WatchContext(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "ctx.yaml")
+	writeFile(t, file, "host: localhost\nmaxConns: 10\n")
+
+	cfg := &RollbackConfig{}
+	reader := NewConfReader("ctx").WithSearchDirs(dir).WithArgs(nil).WithEnv(nil)
+
+	t.Run("callBeforeRead", func(t *testing.T) {
+		_, err := reader.WatchContext(context.Background())
+		assert.Error(t, err)
+	})
+
+	if err := reader.Read(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs, err := reader.WatchContext(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	t.Run("rejectedReloadIsSentToChannel", func(t *testing.T) {
+		writeFile(t, file, "maxConns: 20\n")
+		select {
+		case err := <-errs:
+			var reloadErr *ReloadError
+			assert.ErrorAs(t, err, &reloadErr)
+		case <-time.After(2 * time.Second):
+			t.Fatal("error was not sent")
+		}
+	})
+
+	t.Run("reloads", func(t *testing.T) {
+		writeFile(t, file, "host: localhost\nmaxConns: 30\n")
+		assert.Eventually(t, func() bool {
+			reader.Mutex().RLock()
+			defer reader.Mutex().RUnlock()
+			return cfg.MaxConns == 30
+		}, 2*time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("stopsOnCancel", func(t *testing.T) {
+		cancel()
+		select {
+		case _, ok := <-errs:
+			assert.False(t, ok, "channel should be closed")
+		case <-time.After(2 * time.Second):
+			t.Fatal("channel was not closed")
+		}
+
+		writeFile(t, file, "host: localhost\nmaxConns: 40\n")
+		time.Sleep(50 * time.Millisecond)
+		reader.Mutex().RLock()
+		assert.Equal(t, 30, cfg.MaxConns)
+		reader.Mutex().RUnlock()
+	})
+
+	t.Run("customFS", func(t *testing.T) {
+		r := NewConfReader("ctx").WithFS(fstest.MapFS{"ctx.yaml": {Data: []byte("host: localhost")}}).WithArgs(nil).WithEnv(nil)
+		if err := r.Read(&RollbackConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		_, err := r.WatchContext(context.Background())
+		assert.Error(t, err)
+	})
+}
+
+func Test_Reload(t *testing.T) {
+	fsys := fstest.MapFS{"reload.yaml": {Data: []byte("host: localhost\nmaxConns: 10\n")}}
+	env := map[string]string{}
+	cfg := &RollbackConfig{}
+	reader := NewConfReader("reload").WithFS(fsys).WithArgs(nil).WithEnv(env)
+
+	t.Run("callBeforeRead", func(t *testing.T) {
+		assert.Error(t, reader.Reload())
+	})
+
+	if err := reader.Read(cfg); err != nil {
+		t.Fatal(err)
+	}
+	var changes []FieldChange
+	reader.OnChange(func(_, _ interface{}, ch []FieldChange) {
+		changes = ch
+	})
+
+	t.Run("reloadsFile", func(t *testing.T) {
+		fsys["reload.yaml"] = &fstest.MapFile{Data: []byte("host: localhost\nmaxConns: 20\n")}
+		if assert.NoError(t, reader.Reload()) {
+			assert.Equal(t, 20, cfg.MaxConns)
+			assert.Equal(t, []FieldChange{{Field: "maxconns", Old: 10, New: 20}}, changes)
+		}
+	})
+
+	t.Run("rejectsInvalid", func(t *testing.T) {
+		fsys["reload.yaml"] = &fstest.MapFile{Data: []byte("maxConns: 30\n")}
+		var reloadErr *ReloadError
+		assert.ErrorAs(t, reader.Reload(), &reloadErr)
+		assert.Equal(t, 20, cfg.MaxConns)
+	})
+}
+
+type UnmanagedConfig struct {
+	Db      *WatchDb
+	Hook    func() string
+	Client  interface{}
+	Token   string `mapstructure:"-"`
+	started bool
+}
+
+func Test_ReloadKeepsUnmanagedFields(t *testing.T) {
+	fsys := fstest.MapFS{"unmanaged.yaml": {Data: []byte("db:\n  host: localhost\n  maxConns: 10\n")}}
+	cfg := &UnmanagedConfig{}
+	reader := NewConfReader("unmanaged").WithFS(fsys).WithArgs(nil).WithEnv(nil)
+	if err := reader.Read(cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Hook = func() string { return "hook" }
+	cfg.Client = "client"
+	cfg.Token = "token"
+	cfg.started = true
+
+	var old, new *UnmanagedConfig
+	OnChange(reader, func(o, n *UnmanagedConfig, _ []FieldChange) {
+		old, new = o, n
+	})
+	fsys["unmanaged.yaml"] = &fstest.MapFile{Data: []byte("db:\n  host: localhost\n  maxConns: 20\n")}
+	if !assert.NoError(t, reader.Reload()) {
+		return
+	}
+
+	assert.Equal(t, 20, cfg.Db.MaxConns)
+	if assert.NotNil(t, cfg.Hook) {
+		assert.Equal(t, "hook", cfg.Hook())
+	}
+	assert.Equal(t, "client", cfg.Client)
+	assert.Equal(t, "token", cfg.Token)
+	assert.True(t, cfg.started)
+
+	// snapshots passed to handlers don't share changed values
+	if assert.NotNil(t, old) {
+		assert.Equal(t, 10, old.Db.MaxConns)
+		assert.Equal(t, 20, new.Db.MaxConns)
+		assert.True(t, new.started)
+	}
+}
+
+func Test_ReloadOnSIGHUP(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SIGHUP is not supported on windows")
+	}
+
+	fsys := &testFS{files: fstest.MapFS{"sighup.yaml": {Data: []byte("host: localhost\nmaxConns: 10\n")}}}
+	cfg := &RollbackConfig{}
+	// file from custom FS can not be watched, so only signal triggers reload
+	reader := NewConfReader("sighup").WithFS(fsys).WithArgs(nil).WithEnv(nil).WithReloadOnSIGHUP()
+	if err := reader.Read(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs, err := reader.WatchContext(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	fsys.set("sighup.yaml", "host: localhost\nmaxConns: 20\n")
+	sendSIGHUP(t)
+	assert.Eventually(t, func() bool {
+		reader.Mutex().RLock()
+		defer reader.Mutex().RUnlock()
+		return cfg.MaxConns == 20
+	}, 2*time.Second, 5*time.Millisecond)
+
+	fsys.set("sighup.yaml", "maxConns: 30\n")
+	sendSIGHUP(t)
+	select {
+	case err := <-errs:
+		assert.Error(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("error was not sent")
+	}
+}
+
+// testFS is a file system which files could be changed while config is watched
+type testFS struct {
+	mu    sync.Mutex
+	files fstest.MapFS
+}
+
+func (f *testFS) set(name, data string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[name] = &fstest.MapFile{Data: []byte(data)}
+}
+
+func (f *testFS) Open(name string) (fs.File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.files.Open(name)
+}
+
+func sendSIGHUP(t *testing.T) {
+	t.Helper()
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testEnv is environment that could be changed while config is watched
+type testEnv struct {
+	mu   sync.Mutex
+	vars map[string]string
+}
+
+func (e *testEnv) set(name, val string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.vars[name] = val
+}
+
+func (e *testEnv) lookup(name string) (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	val, ok := e.vars[name]
+	return val, ok
+}
+
+func Test_ReloadEnv(t *testing.T) {
+	env := &testEnv{vars: map[string]string{"HOST": "localhost"}}
+
+	t.Run("manualReload", func(t *testing.T) {
+		cfg := &RollbackConfig{}
+		reader := NewConfReader("reload-env").WithFS(fstest.MapFS{}).WithArgs(nil).WithEnvLookup(env.lookup)
+		if err := reader.Read(cfg); err != nil {
+			t.Fatal(err)
+		}
+
+		env.set("MAXCONNS", "5")
+		if assert.NoError(t, reader.Reload()) {
+			assert.Equal(t, 5, cfg.MaxConns)
+			p, _ := reader.Provenance("maxconns")
+			assert.Equal(t, Source{Kind: SourceEnv, Name: "MAXCONNS", Value: "5"}, p.Source)
+		}
+	})
+
+	t.Run("polling", func(t *testing.T) {
+		cfg := &RollbackConfig{}
+		reader := NewConfReader("reload-env").WithFS(fstest.MapFS{}).WithArgs(nil).WithEnvLookup(env.lookup).
+			WithPollInterval(5 * time.Millisecond)
+		if err := reader.Read(cfg); err != nil {
+			t.Fatal(err)
+		}
+
+		changes := make(chan []FieldChange, 10)
+		reader.OnChange(func(_, _ interface{}, ch []FieldChange) {
+			changes <- ch
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		_, err := reader.WatchContext(ctx)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		env.set("MAXCONNS", "7")
+		select {
+		case ch := <-changes:
+			assert.Equal(t, []FieldChange{{Field: "maxconns", Old: 5, New: 7}}, ch)
+		case <-time.After(2 * time.Second):
+			t.Fatal("config was not reloaded")
+		}
+
+		// nothing changed, so handlers are not called
+		time.Sleep(20 * time.Millisecond)
+		assert.Empty(t, changes)
+	})
+}